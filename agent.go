@@ -0,0 +1,278 @@
+package khan
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"time"
+
+	"khan.rip/rio"
+)
+
+// Agent mode ships this binary to each remote host and runs it there with -l,
+// so items run at local speed instead of one SSH round trip per operation.
+// The child reports back over its stdout as JSON lines (see outevent).
+
+// agentHost is how the parent tells the child who it is, so templates and
+// targeting see the same host as they would over SSH.
+type agentHost struct {
+	Name   string            `json:"name"`
+	Groups []string          `json:"groups,omitempty"`
+	Vars   map[string]string `json:"vars,omitempty"`
+	Login  string            `json:"login,omitempty"`
+}
+
+// runWithAgents runs agent hosts alongside the in-process run for the rest
+func (r *Run) runWithAgents(agents []*Host) error {
+	errs := make(chan error, len(agents)+1)
+	for _, host := range agents {
+		go func(host *Host) {
+			if err := r.runAgent(host); err != nil {
+				errs <- fmt.Errorf("%s: %w", host.Name, err)
+				return
+			}
+			errs <- nil
+		}(host)
+	}
+	go func() {
+		errs <- r.run()
+	}()
+
+	var failed []string
+	for i := 0; i < len(agents)+1; i++ {
+		if err := <-errs; err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func (r *Run) runAgent(host *Host) error {
+	ctx := r.ctx
+	live := host.live
+
+	info, err := live.Info(ctx)
+	if err != nil {
+		return err
+	}
+	bin, err := agentBinary(info)
+	if err != nil {
+		return err
+	}
+
+	// The dry run wrapper has its own pretend tmpdir; the binary needs a real one
+	if live != host.rh {
+		defer live.Cleanup()
+	}
+	tmpdir, err := live.TmpDir(ctx)
+	if err != nil {
+		return err
+	}
+	binpath := path.Join(tmpdir, "khan")
+	if err := r.shipAgent(host, bin, binpath); err != nil {
+		return fmt.Errorf("Failed to copy agent to %s: %w", binpath, err)
+	}
+
+	me, err := json.Marshal(&agentHost{
+		Name:   host.Name,
+		Groups: host.Groups,
+		Vars:   host.Vars,
+		Login:  host.login,
+	})
+	if err != nil {
+		return err
+	}
+	args := []string{"--local", "--agent-child", string(me)}
+	if r.Dry {
+		args = append(args, "--dry")
+	}
+	if r.Diff {
+		args = append(args, "--diff")
+	}
+	if r.Verbose {
+		args = append(args, "--verbose")
+	}
+	if r.Strict {
+		args = append(args, "--strict")
+	}
+
+	env, err := json.Marshal(r.agentenv)
+	if err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	result := make(chan *outevent, 1)
+	go func() {
+		result <- r.readAgent(host, pr)
+	}()
+
+	cmd := rio.Command(ctx, binpath, args...)
+	cmd.Stdin = bytes.NewReader(append(env, '\n'))
+	cmd.Stdout = pw
+	cmd.Stderr = os.Stderr
+	execerr := live.Exec(cmd)
+	pw.Close()
+	done := <-result
+
+	if done == nil {
+		if execerr != nil {
+			return execerr
+		}
+		return fmt.Errorf("Agent exited without a result")
+	}
+	if done.Err != "" {
+		return errors.New(done.Err)
+	}
+	return execerr
+}
+
+// agentEnv is the password_env values from here, where agents can't see
+// them. They go to the child over its stdin rather than its arguments, so
+// they don't show up in ps.
+func (r *Run) agentEnv() map[string]string {
+	r.itemsmu.Lock()
+	defer r.itemsmu.Unlock()
+
+	env := map[string]string{}
+	for _, iitem := range r.inititems {
+		if u, ok := iitem.item.(*User); ok && u.PasswordEnv != "" {
+			if v, ok := os.LookupEnv(u.PasswordEnv); ok {
+				env[u.PasswordEnv] = v
+			}
+		}
+	}
+	return env
+}
+
+// agentBinary picks what to ship: this binary, or one built next to it for
+// the host's platform with khan build --os/--arch (named <module>-<os>-<arch>).
+func agentBinary(info *rio.Info) (string, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if info.OS == runtime.GOOS && info.Arch == runtime.GOARCH {
+		return self, nil
+	}
+
+	bin := strings.TrimSuffix(self, "-"+runtime.GOOS+"-"+runtime.GOARCH) + "-" + info.OS + "-" + info.Arch
+	if _, err := os.Stat(bin); err != nil {
+		return "", fmt.Errorf("Host is %s/%s but this binary is %s/%s, and there's no %s: build it with khan build --os %s --arch %s",
+			info.OS, info.Arch, runtime.GOOS, runtime.GOARCH, bin, info.OS, info.Arch)
+	}
+	return bin, nil
+}
+
+func (r *Run) shipAgent(host *Host, bin, binpath string) error {
+	ctx := r.ctx
+
+	src, err := os.Open(bin)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := host.live.Create(ctx, binpath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return host.live.Chmod(ctx, binpath, 0700)
+}
+
+// readAgent feeds the child's events into our outputter and run totals. Lines
+// that aren't events (warnings, diffs, verbose output) are passed through with
+// the host name. It returns the final "done" event, if there was one.
+func (r *Run) readAgent(host *Host, rd io.Reader) *outevent {
+	br := bufio.NewReader(rd)
+	var done *outevent
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			ev := &outevent{}
+			if json.Unmarshal([]byte(line), ev) != nil || ev.Event == "" {
+				fmt.Printf("%s: %s\n", host.Name, strings.TrimSuffix(line, "\n"))
+			} else {
+				switch ev.Event {
+				case "active":
+					r.out.active(host.Name, ev.Type, ev.Item, ev.Status)
+				case "finish":
+					r.itemsmu.Lock()
+					if ev.Err == "" {
+						r.itemsuccesscount++
+					}
+					r.itemstatuscount[ev.Status]++
+					r.itemsmu.Unlock()
+					r.out.finish(r, host.Name, ev.Type, ev.Item, ev.Status, time.Duration(ev.Nanos), ev.Err)
+				case "done":
+					done = ev
+				}
+			}
+		}
+		if err != nil {
+			return done
+		}
+	}
+}
+
+// agentChild sets up the local host to look like the one the parent is
+// running us for.
+func agentChild(host *Host, arg string) error {
+	var me agentHost
+	if err := json.Unmarshal([]byte(arg), &me); err != nil {
+		return fmt.Errorf("Bad --agent-child: %w", err)
+	}
+	host.Name = me.Name
+	host.Groups = me.Groups
+	host.Vars = me.Vars
+	if me.Login != "" {
+		host.login = me.Login
+	}
+
+	var env map[string]string
+	if err := json.NewDecoder(os.Stdin).Decode(&env); err != nil {
+		return fmt.Errorf("Bad agent environment: %w", err)
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+	}
+	return nil
+}
+
+// agentChildRun is the whole run on the agent's side: no title or summary,
+// the parent prints those.
+func (r *Run) agentChildRun() error {
+	for _, host := range r.Hosts {
+		if _, err := host.rh.User(r.ctx, "root"); err != nil {
+			return err
+		}
+	}
+	if err := r.runinit(); err != nil {
+		return err
+	}
+	return r.run()
+}
+
+func errstr(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -14,6 +14,14 @@ func SetAssetLoader(fn func(string) (io.ReadCloser, error)) {
 	mainassetfn = fn
 }
 
+// mainassetnames lists everything bundled, for items that ship whole
+// directories
+var mainassetnames func() []string
+
+func SetAssetNames(fn func() []string) {
+	mainassetnames = fn
+}
+
 func dummyassetfn(_ string) (io.ReadCloser, error) {
 	_ = bytes.NewReader
 	return nil, os.ErrNotExist
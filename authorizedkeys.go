@@ -0,0 +1,209 @@
+package khan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"khan.rip/rio/util"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// AuthorizedKeys manages a user's ~/.ssh/authorized_keys, in the home
+// directory the host has for them.
+type AuthorizedKeys struct {
+	User string `khan:"user,shortkey"`
+
+	// Keys are authorized_keys lines: [options] type base64 [comment]
+	Keys []string
+
+	// Exclusive makes Keys the whole file. Otherwise keys already there are
+	// kept, and ours are added (or updated, if the options or comment
+	// changed).
+	Exclusive bool
+
+	// Requires lists extra keys to order after, e.g. "package:nginx"
+	Requires []string
+
+	// Notify lists handlers to trigger when this item changes, e.g. "service:nginx#reload"
+	Notify []string
+
+	// Limits which hosts get this item
+	Target
+
+	id int
+}
+
+func (ak *AuthorizedKeys) String() string {
+	return ak.User
+}
+
+func (ak *AuthorizedKeys) SetID(id int) {
+	ak.id = id
+}
+func (ak *AuthorizedKeys) ID() int {
+	return ak.id
+}
+func (ak *AuthorizedKeys) Clone() Item {
+	r := *ak
+	r.id = 0
+	return &r
+}
+
+func (ak *AuthorizedKeys) Validate() error {
+	if ak.User == "" {
+		return errors.New("AuthorizedKeys user is required")
+	}
+	seen := map[string]bool{}
+	for i, key := range ak.Keys {
+		id, err := parseAuthorizedKey(key)
+		if err != nil {
+			return fmt.Errorf("Bad SSH key %d for %s: %w", i+1, ak.User, err)
+		}
+		if seen[id] {
+			return fmt.Errorf("SSH key %d for %s is listed twice", i+1, ak.User)
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+func (ak *AuthorizedKeys) StaticFiles() []string {
+	return nil
+}
+
+func (ak *AuthorizedKeys) After() []string {
+	afters := []string{"user:" + ak.User}
+	afters = append(afters, ak.Requires...)
+	return afters
+}
+func (ak *AuthorizedKeys) Before() []string {
+	return nil
+}
+func (ak *AuthorizedKeys) Provides() []string {
+	return []string{"authorized_keys:" + ak.User}
+}
+func (ak *AuthorizedKeys) Notifies() []string {
+	return ak.Notify
+}
+
+// parseAuthorizedKey checks one authorized_keys line, returning the key in
+// wire format to tell keys apart regardless of options and comments.
+func parseAuthorizedKey(line string) (string, error) {
+	if strings.ContainsAny(line, "\n") {
+		return "", errors.New("One key per entry")
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return "", err
+	}
+	return string(pub.Marshal()), nil
+}
+
+func (ak *AuthorizedKeys) Apply(ctx context.Context, host *Host) (Status, error) {
+	user, err := host.rh.User(ctx, ak.User)
+	if err != nil {
+		return 0, err
+	}
+	if user == nil {
+		return 0, fmt.Errorf("Unknown user %#v", ak.User)
+	}
+	if user.Home == "" {
+		return 0, fmt.Errorf("User %#v has no home directory", ak.User)
+	}
+
+	sshdir := path.Join(user.Home, ".ssh")
+	keyfile := path.Join(sshdir, "authorized_keys")
+
+	dir := &Dir{
+		Path: sshdir,
+		User: ak.User,
+		Mode: 0700,
+	}
+	host.Run.addInner(host, ak, dir)
+	dstatus, err := dir.Apply(ctx, host)
+	if err != nil {
+		return 0, err
+	}
+
+	var old []string
+	if !ak.Exclusive && dstatus != Created {
+		content, err := host.rh.ReadFile(ctx, keyfile)
+		if err != nil && !util.IsErrNotFound(err) {
+			return 0, err
+		}
+		old = splitFileLines(string(content))
+	}
+
+	lines, err := ak.merge(old)
+	if err != nil {
+		return 0, err
+	}
+
+	content := strings.Join(lines, "\n")
+	if len(lines) > 0 {
+		content += "\n"
+	}
+	f := &File{
+		Path:    keyfile,
+		User:    ak.User,
+		Mode:    0600,
+		Content: content,
+	}
+	host.Run.addInner(host, ak, f)
+	fstatus, err := f.Apply(ctx, host)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case fstatus == Created:
+		return Created, nil
+	case fstatus != Unchanged || dstatus != Unchanged:
+		return Modified, nil
+	}
+	return Unchanged, nil
+}
+
+// merge puts our keys into the existing lines: a line with the same key is
+// replaced, and keys that aren't there are appended. Lines that aren't keys
+// (comments, blanks) stay where they are.
+func (ak *AuthorizedKeys) merge(old []string) ([]string, error) {
+	want := map[string]int{}
+	for i, line := range ak.Keys {
+		id, err := parseAuthorizedKey(line)
+		if err != nil {
+			return nil, err
+		}
+		want[id] = i
+	}
+
+	placed := map[int]bool{}
+	var lines []string
+	for _, line := range old {
+		id, err := parseAuthorizedKey(line)
+		if err != nil {
+			lines = append(lines, line)
+			continue
+		}
+		i, ok := want[id]
+		if !ok {
+			lines = append(lines, line)
+			continue
+		}
+		if !placed[i] {
+			lines = append(lines, ak.Keys[i])
+			placed[i] = true
+		}
+	}
+	for i, line := range ak.Keys {
+		if !placed[i] {
+			lines = append(lines, line)
+			placed[i] = true
+		}
+	}
+	return lines, nil
+}
@@ -8,10 +8,12 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
 
 	"github.com/go-bindata/go-bindata/v3"
+	"github.com/spf13/pflag"
 )
 
 type buildrun struct {
@@ -20,7 +22,73 @@ type buildrun struct {
 	cwd         string
 }
 
+// target is a GOOS/GOARCH pair to cross-compile for
+type target struct {
+	os   string
+	arch string
+}
+
+func (t target) String() string {
+	return t.os + "/" + t.arch
+}
+
+func (t target) native() bool {
+	return t.os == runtime.GOOS && t.arch == runtime.GOARCH
+}
+
+// buildtargets works out what to compile for. No flags means a native build
+// with the plain output name.
+func buildtargets(oses, arches, pairs []string) ([]target, error) {
+	var targets []target
+	seen := map[target]bool{}
+	add := func(t target) {
+		if !seen[t] {
+			seen[t] = true
+			targets = append(targets, t)
+		}
+	}
+
+	for _, pair := range pairs {
+		i := strings.IndexByte(pair, '/')
+		if i < 1 || i == len(pair)-1 {
+			return nil, fmt.Errorf("Bad target %#v: Expected os/arch, e.g. linux/arm64", pair)
+		}
+		add(target{os: pair[:i], arch: pair[i+1:]})
+	}
+
+	if len(oses) == 0 && len(arches) == 0 {
+		return targets, nil
+	}
+	if len(oses) == 0 {
+		oses = []string{runtime.GOOS}
+	}
+	if len(arches) == 0 {
+		arches = []string{runtime.GOARCH}
+	}
+	for _, o := range oses {
+		for _, a := range arches {
+			add(target{os: o, arch: a})
+		}
+	}
+	return targets, nil
+}
+
 func build() error {
+	flags := pflag.NewFlagSet("build", pflag.ExitOnError)
+	var oses, arches, pairs []string
+	release := false
+	flags.StringSliceVar(&oses, "os", nil, "Target GOOS (may be repeated; combines with --arch)")
+	flags.StringSliceVar(&arches, "arch", nil, "Target GOARCH (may be repeated; combines with --os)")
+	flags.StringSliceVar(&pairs, "target", nil, "Target os/arch pair (may be repeated)")
+	flags.BoolVar(&release, "release", false, "Build without -race and with CGO_ENABLED=0 for a static binary (implied when cross-compiling)")
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+
+	targets, err := buildtargets(oses, arches, pairs)
+	if err != nil {
+		return err
+	}
 
 	describe := "unknown"
 
@@ -73,7 +141,17 @@ func build() error {
 		cwd: cwd,
 	}
 
-	fmt.Println("Building", wd, "→", outfile)
+	// With explicit targets every output is named for its platform, even a
+	// native one, so a directory of builds is unambiguous.
+	outfiles := []string{outfile}
+	if len(targets) > 0 {
+		outfiles = nil
+		for _, t := range targets {
+			outfiles = append(outfiles, outfile+"-"+t.os+"-"+t.arch)
+		}
+	}
+
+	fmt.Println("Building", wd, "→", strings.Join(outfiles, ", "))
 
 	copybacklist := []string{"go.sum", "go.mod"}
 
@@ -97,6 +175,12 @@ func build() error {
 	for _, match := range matches {
 		base := filepath.Base(match)
 		goname := base + ".go"
+		if inventoryfiles[base] {
+			if err := br.inventory2go(match, wd+"/"+goname); err != nil {
+				return err
+			}
+			continue
+		}
 		if err := br.yaml2go(wd, match, wd+"/"+goname, &assetfs); err != nil {
 			return err
 		}
@@ -113,9 +197,14 @@ func build() error {
 				continue
 			}
 			staticfiledups[file] = true
+			info, err := os.Stat(file)
+			if err != nil {
+				return err
+			}
+			// Directories (from Tree) bring everything under them
 			bc.Input = append(bc.Input, bindata.InputConfig{
 				Path:      file,
-				Recursive: false,
+				Recursive: info.IsDir(),
 			})
 		}
 		fmt.Println("Bundling", len(br.staticfiles), "static files ...")
@@ -125,6 +214,18 @@ func build() error {
 		return err
 	}
 
+	// Written every time, unlike main.go, so older build directories get it too
+	if err := ioutil.WriteFile(wd+"/khan_asset_names.go", []byte(fmt.Sprintf(`package main
+
+import %s %#v
+
+func init() {
+	%s.SetAssetNames(AssetNames)
+}
+`, khanpkgalias, khanpkgname, khanpkgalias)), 0644); err != nil {
+		return err
+	}
+
 	if _, err := os.Stat(wd + "/go.mod"); err != nil {
 		if err := ioutil.WriteFile(wd+"/go.mod", []byte(`module myconfig
 `), 0644); err != nil {
@@ -175,12 +276,15 @@ func main() {
 
 	//fmt.Println("Compiling ...")
 
-	cmd := exec.Command("go", "build", "-race", "-o", cwd+"/"+outfile)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Dir = wd
-	if err := cmd.Run(); err != nil {
-		return err
+	if len(targets) == 0 {
+		if err := br.compile(outfile, target{os: runtime.GOOS, arch: runtime.GOARCH}, release); err != nil {
+			return err
+		}
+	}
+	for i, t := range targets {
+		if err := br.compile(outfiles[i], t, release); err != nil {
+			return fmt.Errorf("Building for %s: %w", t, err)
+		}
 	}
 
 	// Copy back out files that go often changes, but only if you had them in your original
@@ -204,6 +308,28 @@ func main() {
 	return nil
 }
 
+// compile runs go build for one target. The race detector needs cgo and only
+// works natively, so release and cross builds leave it out and are static.
+func (br *buildrun) compile(outfile string, t target, release bool) error {
+	static := release || !t.native()
+
+	args := []string{"build"}
+	if !static {
+		args = append(args, "-race")
+	}
+	args = append(args, "-o", br.cwd+"/"+outfile)
+
+	cmd := exec.Command("go", args...)
+	cmd.Env = append(os.Environ(), "GOOS="+t.os, "GOARCH="+t.arch)
+	if static {
+		cmd.Env = append(cmd.Env, "CGO_ENABLED=0")
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Dir = br.wd
+	return cmd.Run()
+}
+
 func copyglobs(dest string, globs ...string) error {
 	for _, g := range globs {
 		matches, err := filepath.Glob(g)
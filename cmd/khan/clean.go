@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 )
 
@@ -29,5 +30,19 @@ func clean() error {
 		}
 	}
 
+	// Platform builds from khan build --os/--arch: <module>-<os>-<arch>
+	matches, err := filepath.Glob(outfile + "-*-*")
+	if err != nil {
+		return err
+	}
+	for _, match := range matches {
+		if len(strings.Split(strings.TrimPrefix(match, outfile+"-"), "-")) != 2 {
+			continue
+		}
+		if err := os.Remove(match); err != nil {
+			return err
+		}
+	}
+
 	return os.RemoveAll(wd)
 }
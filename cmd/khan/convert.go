@@ -45,11 +45,18 @@ func (err yamlerror) Error() string {
 type yamlhandler func(w *yamlwalker, v *yaml.Node) error
 
 var yamlhandlers = map[string]yamlhandler{
-	"file":    yamlsimplehandler(&khan.File{}),
-	"group":   yamlsimplehandler(&khan.Group{}),
-	"user":    yamlsimplehandler(&khan.User{}),
-	"dir":     yamlsimplehandler(&khan.Dir{}),
-	"service": yamlsimplehandler(&khan.Service{}),
+	"file":            yamlsimplehandler(&khan.File{}),
+	"group":           yamlsimplehandler(&khan.Group{}),
+	"user":            yamluserhandler,
+	"dir":             yamlsimplehandler(&khan.Dir{}),
+	"tree":            yamlsimplehandler(&khan.Tree{}),
+	"symlink":         yamlsimplehandler(&khan.Symlink{}),
+	"edit":            yamlsimplehandler(&khan.Edit{}),
+	"purge_accounts":  yamlsimplehandler(&khan.PurgeAccounts{}),
+	"authorized_keys": yamlsimplehandler(&khan.AuthorizedKeys{}),
+	"service":         yamlsimplehandler(&khan.Service{}),
+	"package":         yamlsimplehandler(&khan.Package{}),
+	"exec":            yamlsimplehandler(&khan.Exec{}),
 }
 
 func yamlkind(kind yaml.Kind) string {
@@ -254,10 +261,28 @@ func (br *buildrun) yaml2struct(w *yamlwalker, v *yaml.Node, si interface{}) err
 		shortvaluet reflect.StructField
 	)
 
+	// Fields of embedded structs (like khan.Target) are parameters of the
+	// item itself, but get written out as the whole embedded struct.
+	embeds := map[string]string{}
+	embedset := map[string]bool{}
+	var embedorder []string
+
 	for i := 0; i < typ.NumField(); i++ {
 		field := val.Field(i)
 		ft := typ.Field(i)
 
+		if ft.Anonymous && ft.Type.Kind() == reflect.Struct {
+			embedorder = append(embedorder, ft.Name)
+			for j := 0; j < ft.Type.NumField(); j++ {
+				eft := ft.Type.Field(j)
+				key := strings.ToLower(eft.Name)
+				fields[key] = field.Field(j)
+				fieldtypes[key] = eft
+				embeds[key] = ft.Name
+			}
+			continue
+		}
+
 		key := strings.ToLower(ft.Name)
 
 		if tv, ok := ft.Tag.Lookup("khan"); ok {
@@ -386,9 +411,20 @@ func (br *buildrun) yaml2struct(w *yamlwalker, v *yaml.Node, si interface{}) err
 				return err
 			}
 
+			if embed, ok := embeds[k.Value]; ok {
+				embedset[embed] = true
+				continue
+			}
+
 			*w.gobuf += fmt.Sprintf("\t\t%s: %#v,\n", ft.Name, f.Interface())
 		}
 
+		for _, embed := range embedorder {
+			if embedset[embed] {
+				*w.gobuf += fmt.Sprintf("\t\t%s: %#v,\n", embed, val.FieldByName(embed).Interface())
+			}
+		}
+
 	} else {
 		if shortvaluek == "" {
 			return w.nodeErrorf(v, "Expected map: Got %s", yamlkind(v.Kind))
@@ -410,6 +446,12 @@ func (br *buildrun) yaml2struct(w *yamlwalker, v *yaml.Node, si interface{}) err
 		}
 	}
 
+	if sit, ok := si.(khan.Targeted); ok {
+		if err := sit.CheckTarget(); err != nil {
+			return w.nodeErrorf(v, "%w", err)
+		}
+	}
+
 	// Include static files into the go binary
 	sif, ok := si.(khan.StaticFiler)
 	if ok {
@@ -510,3 +552,73 @@ func yamlsimplehandler(vv khan.Item) yamlhandler {
 		return nil
 	}
 }
+
+// yamluserhandler crypts a user's password_plain into its password at build
+// time (see khan.CryptPassword). password_env names an environment variable
+// to take the plain password from instead, to keep it out of the yaml. Only
+// the crypt string makes it into the build.
+func yamluserhandler(w *yamlwalker, v *yaml.Node) error {
+	if v.Kind != yaml.MappingNode || len(v.Content)%2 != 0 {
+		return yamlsimplehandler(&khan.User{})(w, v)
+	}
+
+	var plain, plainkey, scheme, schemekey, password, env *yaml.Node
+	var content []*yaml.Node
+	for i := 0; i < len(v.Content); i += 2 {
+		k, vv := v.Content[i], v.Content[i+1]
+		switch k.Value {
+		case "password_plain":
+			if plain != nil {
+				return w.nodeErrorf(k, "User password_plain set multiple times")
+			}
+			plain, plainkey = vv, k
+			continue
+		case "password_scheme":
+			// Only password_env uses it at run time
+			scheme, schemekey = vv, k
+			continue
+		case "password":
+			password = k
+		case "password_env":
+			env = k
+		}
+		content = append(content, k, vv)
+	}
+	if plain == nil {
+		if scheme != nil && env == nil {
+			return w.nodeErrorf(schemekey, "User password_scheme needs password_plain or password_env")
+		}
+		return yamlsimplehandler(&khan.User{})(w, v)
+	}
+
+	// password_plain is crypted here so the password itself isn't built in
+	if env != nil {
+		return w.nodeErrorf(env, "User has both password_plain and password_env")
+	}
+	if password != nil {
+		return w.nodeErrorf(password, "User has both password and password_plain")
+	}
+	if plain.Kind != yaml.ScalarNode {
+		return w.nodeErrorf(plain, "Expected password: Got %s", yamlkind(plain.Kind))
+	}
+	if plain.Value == "" {
+		return w.nodeErrorf(plain, "User password_plain is blank (use blank_password)")
+	}
+
+	schemename := ""
+	if scheme != nil {
+		schemename = scheme.Value
+	}
+	crypt, err := khan.CryptPassword(plain.Value, schemename)
+	if err != nil {
+		return w.nodeErrorf(plainkey, "%w", err)
+	}
+	content = append(content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "password", Line: plainkey.Line, Column: plainkey.Column},
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: crypt, Line: plainkey.Line, Column: plainkey.Column},
+	)
+
+	m := *v
+	m.Content = content
+	return yamlsimplehandler(&khan.User{})(w, &m)
+}
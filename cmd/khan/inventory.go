@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"khan.rip"
+)
+
+// inventoryfiles are project root files holding the host inventory instead of items
+var inventoryfiles = map[string]bool{
+	"inventory.yaml": true,
+	"inventory.yml":  true,
+}
+
+// inventory2go parses the inventory now so mistakes fail the build, and
+// compiles it in as a literal.
+func (br *buildrun) inventory2go(yamlpath, gopath string) error {
+	yamlbuf, err := ioutil.ReadFile(yamlpath)
+	if err != nil {
+		return err
+	}
+
+	inv, err := khan.ParseInventory(yamlbuf)
+	if err != nil {
+		return fmt.Errorf("%s: %w", yamlpath, err)
+	}
+
+	fmt.Println("Bundling inventory of", len(inv.Hosts), "hosts ...")
+
+	gobuf := fmt.Sprintf(`package main
+
+import (
+	%s %#v
+)
+
+func init() {
+	%s.SetInventory(%#v)
+}
+`, khanpkgalias, khanpkgname, khanpkgalias, *inv)
+
+	return ioutil.WriteFile(gopath, []byte(gobuf), 0644)
+}
@@ -1,6 +1,7 @@
 package khan
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -19,6 +20,12 @@ type Dir struct {
 
 	Delete bool
 
+	// Notify lists handlers to trigger when this item changes, e.g. "service:nginx#reload"
+	Notify []string
+
+	// Limits which hosts get this item
+	Target
+
 	id int
 }
 
@@ -68,22 +75,27 @@ func (d *Dir) Before() []string {
 func (d *Dir) Provides() []string {
 	return []string{"path:" + d.Path}
 }
+func (d *Dir) Notifies() []string {
+	return d.Notify
+}
 
-func (d *Dir) Apply(host *Host) (Status, error) {
+func (d *Dir) Apply(ctx context.Context, host *Host) (Status, error) {
 	if d.Delete {
-		_, err := host.rh.Stat(d.Path)
+		_, err := host.rh.Stat(ctx, d.Path)
 		if err != nil && util.IsErrNotFound(err) {
 			return Unchanged, nil
 		}
 		if err != nil {
 			return 0, err
 		}
-		if err := host.rh.Remove(d.Path); err != nil {
+		if err := host.rh.Remove(ctx, d.Path); err != nil {
 			return 0, err
 		}
 		return Deleted, nil
 	}
 
+	warnIfLink(ctx, host, "directory", d.Path)
+
 	mode := d.Mode
 	if mode == 0 {
 		mode = 0755
@@ -106,7 +118,7 @@ func (d *Dir) Apply(host *Host) (Status, error) {
 		return 0, fmt.Errorf("Cannot determine user for managed directory %v", d)
 	}
 
-	user, err := host.rh.User(ustr)
+	user, err := host.rh.User(ctx, ustr)
 	if err != nil {
 		return 0, err
 	}
@@ -120,7 +132,7 @@ func (d *Dir) Apply(host *Host) (Status, error) {
 		return 0, fmt.Errorf("Cannot determine group for managed directory %v", d)
 	}
 
-	group, err := host.rh.Group(gstr)
+	group, err := host.rh.Group(ctx, gstr)
 	if err != nil {
 		return 0, err
 	}
@@ -145,20 +157,20 @@ func (d *Dir) Apply(host *Host) (Status, error) {
 	created := false
 	fpath := d.Path
 
-	fi, err := host.rh.Stat(fpath)
+	fi, err := host.rh.Stat(ctx, fpath)
 	if err != nil {
 
 		if util.IsErrNotFound(err) {
 			// Create the directory
 			created = true
 
-			mkerr := host.rh.MkdirAll(fpath)
+			mkerr := host.rh.MkdirAll(ctx, fpath)
 			if mkerr != nil {
 				return 0, mkerr
 			}
 
 			// Now re-stat. It had better succeed.
-			fi, err = host.rh.Stat(fpath)
+			fi, err = host.rh.Stat(ctx, fpath)
 			if err != nil {
 				return 0, err
 			}
@@ -183,7 +195,7 @@ func (d *Dir) Apply(host *Host) (Status, error) {
 		//fmt.Printf("wantuid %d wantgid %d uid %d gid %d\n", wantuid, wantgid, uid, gid)
 		status = Modified
 
-		if err := host.rh.Chown(fpath, wantuid, wantgid); err != nil {
+		if err := host.rh.Chown(ctx, fpath, wantuid, wantgid); err != nil {
 			return 0, err
 		}
 	}
@@ -192,7 +204,7 @@ func (d *Dir) Apply(host *Host) (Status, error) {
 		//fmt.Printf("current: %o , masked %o , want: %o\n", uint32(fi.Mode()), uint32(fi.Mode())&util.S_justmode, mode)
 		status = Modified
 
-		if err := host.rh.Chmod(fpath, mode); err != nil {
+		if err := host.rh.Chmod(ctx, fpath, mode); err != nil {
 			return 0, err
 		}
 	}
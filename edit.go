@@ -0,0 +1,324 @@
+package khan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"khan.rip/rio/util"
+)
+
+// Edit manages part of a file someone else owns, like /etc/hosts or
+// sshd_config: one line, or a block of lines between marker comments. The
+// rest of the file is left alone.
+type Edit struct {
+	Path string `khan:"path,shortkey"`
+
+	// Line makes sure this line is in the file, appending it if it's missing
+	Line string `khan:"line,shortvalue"`
+
+	// Match is a regexp for the line that Line replaces, e.g.
+	// "^#?PermitRootLogin ". The first matching line is replaced and any
+	// others are removed.
+	Match string
+
+	// Name switches to block mode: Block is kept between "# BEGIN khan
+	// <name>" and "# END khan <name>" lines, appended if they're missing.
+	Name  string
+	Block string
+
+	// Comment starts the marker lines, default "#"
+	Comment string
+
+	// Delete removes the line (every line matching Match, or equal to Line)
+	// or the block, markers and all
+	Delete bool
+
+	// Create makes the file if it's missing. Otherwise that's an error.
+	Create bool
+
+	// Requires lists extra keys to order after, e.g. "package:nginx"
+	Requires []string
+
+	// Notify lists handlers to trigger when this item changes, e.g. "service:nginx#reload"
+	Notify []string
+
+	// Limits which hosts get this item
+	Target
+
+	id int
+}
+
+func (e *Edit) String() string {
+	if e.Name != "" {
+		return e.Path + " " + e.Name
+	}
+	if e.Match != "" {
+		return e.Path + " " + e.Match
+	}
+	return e.Path + " " + e.Line
+}
+
+func (e *Edit) SetID(id int) {
+	e.id = id
+}
+func (e *Edit) ID() int {
+	return e.id
+}
+func (e *Edit) Clone() Item {
+	r := *e
+	r.id = 0
+	return &r
+}
+
+func (e *Edit) Validate() error {
+	if e.Path == "" {
+		return errors.New("Edit path is required")
+	}
+	if e.Name != "" {
+		if e.Line != "" || e.Match != "" {
+			return errors.New("Edit takes a line or a block (name), not both")
+		}
+		if strings.ContainsAny(e.Name, "\n") {
+			return errors.New("Edit block name can't have newlines")
+		}
+		return nil
+	}
+	if e.Block != "" {
+		return errors.New("Edit block needs a name")
+	}
+	if e.Line == "" && !(e.Delete && e.Match != "") {
+		return errors.New("Edit line is required")
+	}
+	if strings.ContainsAny(e.Line, "\n") {
+		return errors.New("Edit line can't have newlines (use a block)")
+	}
+	if e.Match != "" {
+		if _, err := regexp.Compile(e.Match); err != nil {
+			return fmt.Errorf("Bad match %#v: %w", e.Match, err)
+		}
+	}
+	return nil
+}
+
+func (e *Edit) StaticFiles() []string {
+	return nil
+}
+
+func (e *Edit) After() []string {
+	// A File writing the whole thing goes first
+	afters := []string{"path:" + e.Path}
+	afters = append(afters, e.Requires...)
+	return afters
+}
+func (e *Edit) OptionalAfter() []string {
+	return []string{"path:" + e.Path}
+}
+func (e *Edit) Before() []string {
+	return nil
+}
+func (e *Edit) Provides() []string {
+	return []string{"edit:" + e.String()}
+}
+func (e *Edit) Notifies() []string {
+	return e.Notify
+}
+
+func (e *Edit) Apply(ctx context.Context, host *Host) (Status, error) {
+	// Other edits of the same file have to wait their turn
+	defer host.lockPath(e.Path)()
+
+	status := Modified
+	var old []string
+
+	content, err := host.rh.ReadFile(ctx, e.Path)
+	if err != nil {
+		if !util.IsErrNotFound(err) {
+			return 0, err
+		}
+		// Nothing to delete from a file that isn't there
+		if e.Delete {
+			return Unchanged, nil
+		}
+		if !e.Create {
+			return 0, err
+		}
+		status = Created
+	} else {
+		old = splitFileLines(string(content))
+	}
+
+	lines, err := e.edit(old)
+	if err != nil {
+		return 0, err
+	}
+	if status != Created && equalLines(old, lines) {
+		return Unchanged, nil
+	}
+
+	warnIfLink(ctx, host, "file", e.Path)
+	host.Run.out.Active(host.Run, e, status)
+
+	src := stringSource(strings.Join(lines, "\n") + "\n")
+	if len(lines) == 0 {
+		src = stringSource("")
+	}
+	if err := src.scan(false); err != nil {
+		return 0, err
+	}
+
+	if host.Run.Diff {
+		if err := diffFile(ctx, host, e.Path, src, status); err != nil {
+			return 0, err
+		}
+	}
+
+	err = replaceFile(ctx, host, e.Path, src, func(tmpfile string) error {
+		return e.copyperms(ctx, host, tmpfile, status == Created)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return status, nil
+}
+
+// edit returns the file's lines with the line or block in place
+func (e *Edit) edit(lines []string) ([]string, error) {
+	if e.Name != "" {
+		return e.editBlock(lines)
+	}
+
+	var out []string
+	if e.Match != "" {
+		re, err := regexp.Compile(e.Match)
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, line := range lines {
+			if !re.MatchString(line) {
+				out = append(out, line)
+				continue
+			}
+			if !found && !e.Delete {
+				out = append(out, e.Line)
+			}
+			found = true
+		}
+		// A Line that doesn't match its own Match is still only added once
+		if !found && !e.Delete && !containsString(out, e.Line) {
+			out = append(out, e.Line)
+		}
+		return out, nil
+	}
+
+	for _, line := range lines {
+		if !(e.Delete && line == e.Line) {
+			out = append(out, line)
+		}
+	}
+	if !e.Delete && !containsString(out, e.Line) {
+		out = append(out, e.Line)
+	}
+	return out, nil
+}
+
+func (e *Edit) editBlock(lines []string) ([]string, error) {
+	comment := e.Comment
+	if comment == "" {
+		comment = "#"
+	}
+	begin := comment + " BEGIN khan " + e.Name
+	end := comment + " END khan " + e.Name
+
+	first, last := -1, -1
+	for i, line := range lines {
+		if first == -1 && line == begin {
+			first = i
+		} else if first != -1 && line == end {
+			last = i
+			break
+		}
+	}
+	if first != -1 && last == -1 {
+		return nil, fmt.Errorf("%s has %#v without %#v", e.Path, begin, end)
+	}
+
+	var block []string
+	if !e.Delete {
+		block = append(block, begin)
+		if e.Block != "" {
+			block = append(block, strings.Split(strings.TrimSuffix(e.Block, "\n"), "\n")...)
+		}
+		block = append(block, end)
+	}
+
+	if first == -1 {
+		return append(append([]string{}, lines...), block...), nil
+	}
+	out := append([]string{}, lines[:first]...)
+	out = append(out, block...)
+	return append(out, lines[last+1:]...), nil
+}
+
+// copyperms gives the temp file the owner and mode of the file it replaces
+func (e *Edit) copyperms(ctx context.Context, host *Host, tmpfile string, created bool) error {
+	if created {
+		return host.rh.Chmod(ctx, tmpfile, 0644)
+	}
+
+	fi, err := host.rh.Stat(ctx, e.Path)
+	if err != nil {
+		return err
+	}
+	ufi, err := util.ConvertStat(fi)
+	if err != nil {
+		return err
+	}
+	tfi, err := host.rh.Stat(ctx, tmpfile)
+	if err != nil {
+		return err
+	}
+	tufi, err := util.ConvertStat(tfi)
+	if err != nil {
+		return err
+	}
+
+	if ufi.Fuid != tufi.Fuid || ufi.Fgid != tufi.Fgid {
+		if err := host.rh.Chown(ctx, tmpfile, ufi.Fuid, ufi.Fgid); err != nil {
+			return err
+		}
+	}
+	return host.rh.Chmod(ctx, tmpfile, fi.Mode()&util.S_justmode)
+}
+
+// splitFileLines splits text into lines, without the final newline's empty line
+func splitFileLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+func containsString(list []string, want string) bool {
+	for _, s := range list {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,175 @@
+package khan
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+
+	"khan.rip/rio"
+	"khan.rip/rio/util"
+)
+
+type Exec struct {
+	// Name is an optional label. If set, the item provides "exec:<name>".
+	Name string
+
+	// Cmd is the program to run. In Shell mode it is a whole shell command line.
+	Cmd  string `khan:"cmd,shortvalue"`
+	Args []string
+
+	Dir string
+	Env []string // KEY=value pairs
+
+	// Shell runs Cmd through a login shell, which gives you a working environment.
+	Shell bool
+
+	// Guards that keep the command idempotent. Creates skips the command if the
+	// path already exists. Unless skips it if the shell command succeeds, and
+	// OnlyIf skips it if the shell command fails. Guard commands are read-only
+	// and always run, even during a dry run.
+	Creates string
+	Unless  string
+	OnlyIf  string
+
+	// ReadOnly marks the command as having no side effects. It will actually
+	// run during a dry run.
+	ReadOnly bool
+
+	// Requires lists extra keys to order after, e.g. "package:nginx"
+	Requires []string
+
+	// Notify lists handlers to trigger when this item changes, e.g. "service:nginx#reload"
+	Notify []string
+
+	// Limits which hosts get this item
+	Target
+
+	id int
+}
+
+func (e *Exec) String() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return strings.TrimPrefix(e.cmd(context.Background()).String(), "$ ")
+}
+
+func (e *Exec) SetID(id int) {
+	e.id = id
+}
+func (e *Exec) ID() int {
+	return e.id
+}
+func (e *Exec) Clone() Item {
+	r := *e
+	r.id = 0
+	return &r
+}
+
+func (e *Exec) Validate() error {
+	if e.Cmd == "" {
+		return errors.New("Exec cmd is required")
+	}
+	for _, kv := range e.Env {
+		if strings.IndexByte(kv, '=') < 1 {
+			return errors.New("Exec env must be KEY=value pairs")
+		}
+	}
+	return nil
+}
+
+func (e *Exec) StaticFiles() []string {
+	return nil
+}
+
+func (e *Exec) After() []string {
+	return e.Requires
+}
+func (e *Exec) Before() []string {
+	return nil
+}
+func (e *Exec) Provides() []string {
+	if e.Name != "" {
+		return []string{"exec:" + e.Name}
+	}
+	return nil
+}
+func (e *Exec) Notifies() []string {
+	return e.Notify
+}
+
+func (e *Exec) cmd(ctx context.Context) *rio.Cmd {
+	cmd := rio.Command(ctx, e.Cmd, e.Args...)
+	cmd.Dir = e.Dir
+	cmd.Shell = e.Shell
+	cmd.ReadOnly = e.ReadOnly
+	for _, kv := range e.Env {
+		eq := strings.IndexByte(kv, '=')
+		cmd.Env = append(cmd.Env, [2]string{kv[:eq], kv[eq+1:]})
+	}
+	return cmd
+}
+
+// guard runs a read-only shell command and reports whether it succeeded.
+func (e *Exec) guard(ctx context.Context, host *Host, line string) (bool, error) {
+	cmd := rio.ReadOnlyCommand(ctx, line)
+	cmd.Shell = true
+	cmd.Dir = e.Dir
+	err := host.rh.Exec(cmd)
+	if err == nil {
+		return true, nil
+	}
+	var cmderr *rio.CmdErr
+	if errors.As(err, &cmderr) {
+		return false, nil
+	}
+	var patherr *os.PathError
+	if errors.As(err, &patherr) {
+		return false, nil
+	}
+	return false, err
+}
+
+func (e *Exec) Apply(ctx context.Context, host *Host) (Status, error) {
+	if e.Creates != "" {
+		_, err := host.rh.Stat(ctx, e.Creates)
+		if err == nil {
+			return Unchanged, nil
+		}
+		if !util.IsErrNotFound(err) {
+			return 0, err
+		}
+	}
+	if e.Unless != "" {
+		ok, err := e.guard(ctx, host, e.Unless)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return Unchanged, nil
+		}
+	}
+	if e.OnlyIf != "" {
+		ok, err := e.guard(ctx, host, e.OnlyIf)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return Unchanged, nil
+		}
+	}
+
+	if !e.ReadOnly {
+		host.Run.out.Active(host.Run, e, Modified)
+	}
+
+	if err := host.rh.Exec(e.cmd(ctx)); err != nil {
+		return 0, err
+	}
+
+	if e.ReadOnly {
+		return Unchanged, nil
+	}
+	return Modified, nil
+}
@@ -1,7 +1,7 @@
 package khan
 
 import (
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -9,6 +9,7 @@ import (
 	"os/user"
 	"strings"
 
+	"khan.rip/rio"
 	"khan.rip/rio/util"
 
 	"github.com/pmezard/go-difflib/difflib"
@@ -36,8 +37,22 @@ type File struct {
 	// a jinja2 style template engine. (See https://github.com/flosch/pongo2)
 	Template string
 
+	// Binary copies content byte for byte. Otherwise text gets a trailing
+	// newline if it's missing one. Content that isn't valid UTF-8 is always
+	// treated as binary.
+	Binary bool
+
 	Delete bool
 
+	// Requires lists extra keys to order after, e.g. "package:nginx"
+	Requires []string
+
+	// Notify lists handlers to trigger when this item changes, e.g. "service:nginx#reload"
+	Notify []string
+
+	// Limits which hosts get this item
+	Target
+
 	id int
 }
 
@@ -61,6 +76,9 @@ func (f *File) Validate() error {
 	if f.Path == "" {
 		return errors.New("File path is required")
 	}
+	if f.Binary && f.Template != "" {
+		return errors.New("Binary files can't be templates")
+	}
 	return nil
 }
 
@@ -86,6 +104,7 @@ func (f *File) After() []string {
 	if f.Group != "" {
 		afters = append(afters, "group:"+f.Group)
 	}
+	afters = append(afters, f.Requires...)
 	return afters
 }
 func (f *File) Before() []string {
@@ -94,10 +113,13 @@ func (f *File) Before() []string {
 func (f *File) Provides() []string {
 	return []string{"path:" + f.Path}
 }
+func (f *File) Notifies() []string {
+	return f.Notify
+}
 
-func (f *File) Apply(host *Host) (Status, error) {
+func (f *File) Apply(ctx context.Context, host *Host) (Status, error) {
 	if f.Delete {
-		_, err := host.rh.Stat(f.Path)
+		_, err := host.rh.Stat(ctx, f.Path)
 		if err != nil && util.IsErrNotFound(err) {
 			return Unchanged, nil
 		}
@@ -105,13 +127,15 @@ func (f *File) Apply(host *Host) (Status, error) {
 		if err != nil {
 			return 0, err
 		}
-		if err := host.rh.Remove(f.Path); err != nil {
+		if err := host.rh.Remove(ctx, f.Path); err != nil {
 			return 0, err
 		}
 		return Deleted, nil
 	}
 
-	content := f.Content
+	warnIfLink(ctx, host, "file", f.Path)
+
+	var src *fileSource
 
 	engine := f.Template
 	if engine == "1" || engine == "true" || engine == "yes" || engine == "pongo" {
@@ -119,61 +143,55 @@ func (f *File) Apply(host *Host) (Status, error) {
 	}
 
 	if engine == "pongo2" {
+		var (
+			content string
+			err     error
+		)
 		if f.Src != "" {
-			var err error
-			if content, err = executePackedTemplateFile(host, f.Src); err != nil {
+			if content, err = executePackedTemplateFile(ctx, host, f.Src); err != nil {
 				return 0, err
 			}
 		} else if f.Local != "" {
 			return 0, fmt.Errorf("FIXME template local mode not supported yet. (security considerations?)")
 		} else {
-			var err error
-			if content, err = executePackedTemplateString(host, f.Content); err != nil {
+			if content, err = executePackedTemplateString(ctx, host, f.Content); err != nil {
 				return 0, err
 			}
 		}
+		src = stringSource(content)
 	} else if engine == "" {
 		// raw file mode
 		if f.Src != "" {
-			fh, err := host.Run.assetfn(f.Src)
-			if err != nil {
-				return 0, err
-			}
-			defer fh.Close()
-			buf := &bytes.Buffer{}
-			if _, err := io.Copy(buf, fh); err != nil {
-				return 0, err
-			}
-			content = buf.String()
+			src = &fileSource{open: func() (io.ReadCloser, error) {
+				return host.Run.assetfn(f.Src)
+			}}
 		} else if f.Local != "" {
 			// copy from another path on managed host
-			srcbuf, err := host.rh.ReadFile(f.Local)
-			if err != nil {
-				return 0, err
-			}
-			content = string(srcbuf)
+			src = &fileSource{open: func() (io.ReadCloser, error) {
+				return host.rh.Open(ctx, f.Local)
+			}}
+		} else {
+			// assume Content is the content. (Blank means a blank file.)
+			src = stringSource(f.Content)
 		}
-		// else: assume Content is the content. (Blank means a blank file.)
 	} else {
 		return 0, fmt.Errorf("Unknown template engine %#v", engine)
 	}
 
-	// If content is not empty, make sure it is a valid text file and ends with a newline.
-	if len(content) > 0 && !strings.HasSuffix(content, "\n") {
-		content += "\n"
+	if engine == "" && f.Src == "" && f.Local != "" {
+		return f.applyLocal(ctx, host, src)
 	}
 
-	var (
-		buf []byte
-		err error
-	)
-
-	buf, err = host.rh.ReadFile(f.Path)
+	// Compare checksums so an unchanged file never crosses the wire
+	if err := src.scan(f.Binary); err != nil {
+		return 0, err
+	}
+	have, err := host.rh.Checksum(ctx, f.Path, rio.SHA256)
 
 	status := Modified
 
-	if err == nil && bytes.Compare(buf, []byte(content)) == 0 {
-		pstatus, err := f.applyperms(host, f.Path)
+	if err == nil && have == src.sum {
+		pstatus, err := f.applyperms(ctx, host, f.Path)
 		if err != nil {
 			return 0, err
 		}
@@ -191,60 +209,153 @@ func (f *File) Apply(host *Host) (Status, error) {
 	}
 
 	if host.Run.Diff {
-		// This is cute but actually ugly.
-		// import "github.com/sergi/go-diff/diffmatchpatch"
-		//dmp := diffmatchpatch.New()
-		//diffs := dmp.DiffMain(string(buf), content, true)
-		//fmt.Println(dmp.DiffPrettyText(diffs))
-
-		// this seems nicer
-		diff := difflib.UnifiedDiff{
-			A:        difflib.SplitLines(string(buf)),
-			B:        difflib.SplitLines(content),
-			FromFile: f.Path,
-			ToFile:   f.Path,
-			Context:  3,
-		}
-		difftxt, err := difflib.GetUnifiedDiffString(diff)
-		if err != nil {
+		if err := diffFile(ctx, host, f.Path, src, status); err != nil {
 			return 0, err
 		}
-		fmt.Print(difftxt)
 	}
 
-	// Try to make this as atomic as possible by doing the write to a temp
-	// file, getting the perms right, and when finished doing a mv to the
-	// final path.
+	err = replaceFile(ctx, host, f.Path, src, func(tmpfile string) error {
+		_, err := f.applyperms(ctx, host, tmpfile)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return status, nil
+}
 
-	tmpfile, err := host.rh.TmpFile()
+// applyLocal copies a file from elsewhere on the host. Both checksums are
+// taken there, and only when they differ is the source read, once, straight
+// into the temp file.
+func (f *File) applyLocal(ctx context.Context, host *Host, src *fileSource) (Status, error) {
+	want, err := host.rh.Checksum(ctx, f.Local, rio.SHA256)
 	if err != nil {
 		return 0, err
 	}
 
-	fh, err := host.rh.Create(tmpfile)
+	status := Modified
+	have, err := host.rh.Checksum(ctx, f.Path, rio.SHA256)
+	if err != nil {
+		if !util.IsErrNotFound(err) {
+			return 0, err
+		}
+		status = Created
+	} else if have == want {
+		return f.applyperms(ctx, host, f.Path)
+	}
+
+	tmpfile, err := host.rh.TmpFile(ctx)
+	if err != nil {
+		return 0, err
+	}
+	fh, err := host.rh.Create(ctx, tmpfile)
 	if err != nil {
 		return 0, err
 	}
 	defer fh.Close()
-	if _, err := fh.Write([]byte(content)); err != nil {
+	if err := src.scanTo(fh, f.Binary); err != nil {
 		return 0, err
 	}
 	if err := fh.Close(); err != nil {
 		return 0, err
 	}
 
-	if _, err := f.applyperms(host, tmpfile); err != nil {
-		return 0, err
+	if status == Modified && src.sum == have {
+		// Only the newline we add to text was missing from the source
+		if err := host.rh.Remove(ctx, tmpfile); err != nil {
+			return 0, err
+		}
+		return f.applyperms(ctx, host, f.Path)
 	}
 
-	if err := host.rh.Rename(tmpfile, f.Path); err != nil {
-		return 0, err
+	host.Run.out.Active(host.Run, f, status)
+	if host.Run.Diff {
+		if err := diffFile(ctx, host, f.Path, src, status); err != nil {
+			return 0, err
+		}
 	}
 
+	if _, err := f.applyperms(ctx, host, tmpfile); err != nil {
+		return 0, err
+	}
+	if err := host.rh.Rename(ctx, tmpfile, f.Path); err != nil {
+		return 0, err
+	}
 	return status, nil
 }
 
-func (f *File) applyperms(host *Host, fpath string) (Status, error) {
+// replaceFile writes src over fpath. Try to make this as atomic as possible
+// by doing the write to a temp file, getting the perms right (perms is given
+// the temp file's path), and when finished doing a mv to the final path.
+func replaceFile(ctx context.Context, host *Host, fpath string, src *fileSource, perms func(string) error) error {
+	tmpfile, err := host.rh.TmpFile(ctx)
+	if err != nil {
+		return err
+	}
+
+	fh, err := host.rh.Create(ctx, tmpfile)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	if err := src.copyTo(fh); err != nil {
+		return err
+	}
+	if err := fh.Close(); err != nil {
+		return err
+	}
+
+	if err := perms(tmpfile); err != nil {
+		return err
+	}
+
+	return host.rh.Rename(ctx, tmpfile, fpath)
+}
+
+// diffFile prints what replacing fpath with src would change, for --diff.
+// src must have been scanned.
+func diffFile(ctx context.Context, host *Host, fpath string, src *fileSource, status Status) error {
+	if src.binary {
+		fmt.Printf("Binary file %s differs\n", fpath)
+		return nil
+	}
+
+	// Only now do we need the old content, and the new content in memory
+	var old []byte
+	if status == Modified {
+		var err error
+		if old, err = host.rh.ReadFile(ctx, fpath); err != nil {
+			return err
+		}
+	}
+	content, err := src.text()
+	if err != nil {
+		return err
+	}
+
+	// This is cute but actually ugly.
+	// import "github.com/sergi/go-diff/diffmatchpatch"
+	//dmp := diffmatchpatch.New()
+	//diffs := dmp.DiffMain(string(old), content, true)
+	//fmt.Println(dmp.DiffPrettyText(diffs))
+
+	// this seems nicer
+	diff := difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(old)),
+		B:        difflib.SplitLines(content),
+		FromFile: fpath,
+		ToFile:   fpath,
+		Context:  3,
+	}
+	difftxt, err := difflib.GetUnifiedDiffString(diff)
+	if err != nil {
+		return err
+	}
+	fmt.Print(difftxt)
+	return nil
+}
+
+func (f *File) applyperms(ctx context.Context, host *Host, fpath string) (Status, error) {
 	mode := f.Mode
 	if mode == 0 {
 		mode = 0644
@@ -267,7 +378,7 @@ func (f *File) applyperms(host *Host, fpath string) (Status, error) {
 		return 0, fmt.Errorf("Cannot determine user for managed file %v", f)
 	}
 
-	user, err := host.rh.User(ustr)
+	user, err := host.rh.User(ctx, ustr)
 	if err != nil {
 		return 0, err
 	}
@@ -281,7 +392,7 @@ func (f *File) applyperms(host *Host, fpath string) (Status, error) {
 		return 0, fmt.Errorf("Cannot determine group for managed file %v", f)
 	}
 
-	group, err := host.rh.Group(gstr)
+	group, err := host.rh.Group(ctx, gstr)
 	if err != nil {
 		return 0, err
 	}
@@ -303,7 +414,7 @@ func (f *File) applyperms(host *Host, fpath string) (Status, error) {
 	wantuid = user.Uid
 	wantgid = group.Gid
 
-	fi, err := host.rh.Stat(fpath)
+	fi, err := host.rh.Stat(ctx, fpath)
 	if err != nil {
 		return 0, err
 	}
@@ -322,7 +433,7 @@ func (f *File) applyperms(host *Host, fpath string) (Status, error) {
 		//fmt.Printf("wantuid %d wantgid %d uid %d gid %d\n", wantuid, wantgid, uid, gid)
 		status = Modified
 
-		if err := host.rh.Chown(fpath, wantuid, wantgid); err != nil {
+		if err := host.rh.Chown(ctx, fpath, wantuid, wantgid); err != nil {
 			return 0, err
 		}
 	}
@@ -331,7 +442,7 @@ func (f *File) applyperms(host *Host, fpath string) (Status, error) {
 		//fmt.Printf("current: %o , masked %o , want: %o\n", uint32(fi.Mode()), uint32(fi.Mode())&util.S_justmode, mode)
 		status = Modified
 
-		if err := host.rh.Chmod(fpath, mode); err != nil {
+		if err := host.rh.Chmod(ctx, fpath, mode); err != nil {
 			return 0, err
 		}
 	}
@@ -0,0 +1,142 @@
+package khan
+
+import (
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"strings"
+	"unicode/utf8"
+
+	"khan.rip/rio"
+)
+
+// fileSource is where a File's content comes from. It's opened once to
+// checksum and again to write, so big files stream instead of sitting in
+// memory.
+type fileSource struct {
+	open func() (io.ReadCloser, error)
+
+	// Set by scan
+	sum     string
+	binary  bool
+	newline bool // text missing its trailing newline
+}
+
+func stringSource(s string) *fileSource {
+	return &fileSource{open: func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader(s)), nil
+	}}
+}
+
+// scan checksums the content as it will be written, and decides whether it
+// is text or binary.
+func (fs *fileSource) scan(binary bool) error {
+	return fs.scanTo(ioutil.Discard, binary)
+}
+
+// scanTo is scan, writing the content to w on the way, so a source that's
+// expensive to read is only read once.
+func (fs *fileSource) scanTo(w io.Writer, binary bool) error {
+	r, err := fs.open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	h, err := rio.NewHash(rio.SHA256)
+	if err != nil {
+		return err
+	}
+	check := &utf8check{}
+	if _, err := io.Copy(io.MultiWriter(h, check, w), r); err != nil {
+		return err
+	}
+	if err := r.Close(); err != nil {
+		return err
+	}
+
+	fs.binary = binary || !check.valid()
+	if !fs.binary && check.n > 0 && check.last != '\n' {
+		fs.newline = true
+		h.Write([]byte("\n"))
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return err
+		}
+	}
+	fs.sum = hex.EncodeToString(h.Sum(nil))
+	return nil
+}
+
+func (fs *fileSource) copyTo(w io.Writer) error {
+	r, err := fs.open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+	if fs.newline {
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return err
+		}
+	}
+	return r.Close()
+}
+
+// text reads the whole content, for diffs
+func (fs *fileSource) text() (string, error) {
+	b := &strings.Builder{}
+	if err := fs.copyTo(b); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// utf8check validates UTF-8 as it streams past, including runes split
+// across writes.
+type utf8check struct {
+	n       int64
+	last    byte
+	partial []byte
+	invalid bool
+}
+
+func (c *utf8check) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	c.n += int64(len(p))
+	c.last = p[len(p)-1]
+	if c.invalid {
+		return len(p), nil
+	}
+
+	b := p
+	if len(c.partial) > 0 {
+		b = append(c.partial, p...)
+		c.partial = nil
+	}
+	for len(b) > 0 {
+		if b[0] < utf8.RuneSelf {
+			b = b[1:]
+			continue
+		}
+		r, size := utf8.DecodeRune(b)
+		if r == utf8.RuneError && size == 1 {
+			if !utf8.FullRune(b) {
+				c.partial = append([]byte(nil), b...)
+			} else {
+				c.invalid = true
+			}
+			break
+		}
+		b = b[size:]
+	}
+	return len(p), nil
+}
+
+func (c *utf8check) valid() bool {
+	return !c.invalid && len(c.partial) == 0
+}
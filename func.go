@@ -1,13 +1,18 @@
 package khan
 
 import (
+	"context"
 	"errors"
 )
 
-type FuncType func(*Host) (Status, error)
+type FuncType func(context.Context, *Host) (Status, error)
 
 type Function struct {
 	Fn FuncType
+
+	// Limits which hosts get this item
+	Target
+
 	id int
 }
 
@@ -54,6 +59,6 @@ func (f *Function) Provides() []string {
 	return nil
 }
 
-func (f *Function) Apply(host *Host) (Status, error) {
-	return f.Fn(host)
+func (f *Function) Apply(ctx context.Context, host *Host) (Status, error) {
+	return f.Fn(ctx, host)
 }
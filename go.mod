@@ -7,10 +7,11 @@ require (
 	github.com/flosch/pongo2/v4 v4.0.2
 	github.com/go-bindata/go-bindata/v3 v3.1.3
 	github.com/keegancsmith/shell v0.0.0-20160208231706-ccb53e0c7c5c
+	github.com/kevinburke/ssh_config v1.2.0
+	github.com/pkg/sftp v1.13.5
 	github.com/pmezard/go-difflib v1.0.0
 	github.com/spf13/pflag v1.0.5
-	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
-	golang.org/x/sys v0.0.0-20201223074533-0d417f636930 // indirect
+	golang.org/x/crypto v0.0.0-20211215153901-e495a2d5b3d3
 	golang.org/x/term v0.0.0-20201210144234-2321bbc49cbf // indirect
 	gopkg.in/yaml.v3 v3.0.0-20200615113413-eeeca48fe776
 )
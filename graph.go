@@ -0,0 +1,122 @@
+package khan
+
+import (
+	"fmt"
+	"strings"
+)
+
+// validateGraph checks the item graph before anything is applied. A cycle
+// between After and Before would deadlock the run, so it is always an error.
+// An After key that nothing provides is ignored by the scheduler, which
+// usually means a typo. That is a warning, or an error in strict mode. A key
+// ending in "*" is a prefix, and matching nothing is fine.
+//
+// always have itemsmu locked before calling this
+func (r *Run) validateGraph() error {
+	deps := map[int][]int{}
+	warned := map[string]bool{}
+	var unresolved []string
+
+	for _, item := range r.items {
+		im := r.meta[item.ID()]
+		hk := im.host.Key() + "-"
+
+		optional := map[string]bool{}
+		if oa, ok := item.(OptionalAfterer); ok {
+			for _, after := range oa.OptionalAfter() {
+				optional[after] = true
+			}
+		}
+
+		for _, after := range item.After() {
+			if strings.HasSuffix(after, "*") {
+				// A prefix: whatever provides matching keys, if anything
+				prefix := hk + strings.TrimSuffix(after, "*")
+				for key, p := range r.providers {
+					if strings.HasPrefix(key, prefix) && p.ID() != item.ID() {
+						deps[item.ID()] = append(deps[item.ID()], p.ID())
+					}
+				}
+				continue
+			}
+			p, ok := r.providers[hk+after]
+			if !ok {
+				if optional[after] {
+					continue
+				}
+				w := im.source + " " + after
+				if warned[w] {
+					// same item cloned for another host
+					continue
+				}
+				warned[w] = true
+				if r.Strict {
+					unresolved = append(unresolved, fmt.Sprintf("%s: Nothing provides %#v", im.describe(r), after))
+				} else {
+					Warnf("%s: Nothing provides %#v", im.describe(r), after)
+				}
+				continue
+			}
+			deps[item.ID()] = append(deps[item.ID()], p.ID())
+		}
+
+		// Items providing a key listed in another item's Before wait on that item.
+		for _, pr := range item.Provides() {
+			for _, bef := range r.befores[hk+pr] {
+				if p, ok := r.providers[bef]; ok {
+					deps[item.ID()] = append(deps[item.ID()], p.ID())
+				}
+			}
+		}
+	}
+
+	var cycles []string
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[int]int{}
+	var stack []int
+
+	var visit func(id int)
+	visit = func(id int) {
+		state[id] = visiting
+		stack = append(stack, id)
+		for _, dep := range deps[id] {
+			switch state[dep] {
+			case unvisited:
+				visit(dep)
+			case visiting:
+				// found a back edge: the cycle is the stack from dep to here
+				start := len(stack) - 1
+				for stack[start] != dep {
+					start--
+				}
+				chunks := []string{}
+				for _, c := range stack[start:] {
+					chunks = append(chunks, r.meta[c].describe(r))
+				}
+				chunks = append(chunks, r.meta[dep].describe(r))
+				cycles = append(cycles, strings.Join(chunks, "\n\t→ "))
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[id] = visited
+	}
+
+	for _, item := range r.items {
+		if state[item.ID()] == unvisited {
+			visit(item.ID())
+		}
+	}
+
+	if len(cycles) > 0 {
+		return fmt.Errorf("Dependency cycle:\n\t%s", strings.Join(cycles, "\nDependency cycle:\n\t"))
+	}
+	if len(unresolved) > 0 {
+		return fmt.Errorf("%d unresolved dependencies (strict mode):\n\t%s", len(unresolved), strings.Join(unresolved, "\n\t"))
+	}
+	return nil
+}
@@ -1,17 +1,33 @@
 package khan
 
 import (
+	"context"
 	"fmt"
+	"strings"
 
 	"khan.rip/rio"
+	"khan.rip/rio/util"
 )
 
 type Group struct {
 	Name string `khan:"name,shortvalue"`
-	Gid  uint32
+
+	// Gid 0 (except for root) leaves it to groupadd, or as it is
+	Gid uint32
+
+	// Members are users to have in the group, besides those with it as
+	// their primary group. Don't also list the group in their User.Groups:
+	// that orders each after the other.
+	Members []string
+
+	// Exclusive takes out members that aren't in Members
+	Exclusive bool
 
 	Delete bool
 
+	// Limits which hosts get this item
+	Target
+
 	id int
 }
 
@@ -30,9 +46,30 @@ func (g *Group) Clone() Item {
 	r.id = 0
 	return &r
 }
-func (g *Group) After() []string {
+func (g *Group) Validate() error {
+	seen := map[string]bool{}
+	for _, name := range g.Members {
+		if name == "" || strings.ContainsAny(name, ", :") {
+			return fmt.Errorf("Bad member %#v for group %s", name, g.Name)
+		}
+		if seen[name] {
+			return fmt.Errorf("Member %s is listed twice for group %s", name, g.Name)
+		}
+		seen[name] = true
+	}
 	return nil
 }
+
+func (g *Group) After() []string {
+	if g.Delete {
+		return nil
+	}
+	var afters []string
+	for _, name := range g.Members {
+		afters = append(afters, "user:"+name)
+	}
+	return afters
+}
 func (g *Group) Before() []string {
 	return nil
 }
@@ -44,8 +81,8 @@ func (g *Group) Provides() []string {
 	}
 }
 
-func (g *Group) Apply(host *Host) (Status, error) {
-	old, err := host.rh.Group(g.Name)
+func (g *Group) Apply(ctx context.Context, host *Host) (Status, error) {
+	old, err := host.rh.Group(ctx, g.Name)
 	if err != nil {
 		return 0, err
 	}
@@ -54,7 +91,7 @@ func (g *Group) Apply(host *Host) (Status, error) {
 		if old == nil {
 			return Unchanged, nil
 		}
-		if err := host.rh.DeleteGroup(g.Name); err != nil {
+		if err := host.rh.DeleteGroup(ctx, g.Name); err != nil {
 			return 0, err
 		}
 		return Deleted, nil
@@ -66,14 +103,20 @@ func (g *Group) Apply(host *Host) (Status, error) {
 	}
 
 	if old == nil {
-		if err := host.rh.CreateGroup(v); err != nil {
+		v.Members = g.Members
+		if err := host.rh.CreateGroup(ctx, v); err != nil {
 			return 0, err
 		}
 		return Created, nil
 	}
 
-	if old.Gid != g.Gid {
-		if err := host.rh.UpdateGroup(v); err != nil {
+	if g.Gid == 0 && g.Name != "root" {
+		v.Gid = old.Gid
+	}
+	v.Members = g.members(old.Members)
+
+	if old.Gid != v.Gid || !util.SameNames(old.Members, v.Members) {
+		if err := host.rh.UpdateGroup(ctx, v); err != nil {
 			return 0, err
 		}
 		return Modified, nil
@@ -81,3 +124,32 @@ func (g *Group) Apply(host *Host) (Status, error) {
 
 	return Unchanged, nil
 }
+
+// memberGroups returns the groups on host that have their members managed
+// by a Group item. Users leave their membership in those alone.
+func (r *Run) memberGroups(host *Host) map[string]bool {
+	r.itemsmu.Lock()
+	defer r.itemsmu.Unlock()
+
+	groups := map[string]bool{}
+	for _, item := range r.items {
+		g, ok := item.(*Group)
+		if !ok || g.Delete || (len(g.Members) == 0 && !g.Exclusive) {
+			continue
+		}
+		if im := r.meta[item.ID()]; im != nil && im.host == host {
+			groups[g.Name] = true
+		}
+	}
+	return groups
+}
+
+// members is who should be in the group, given who is
+func (g *Group) members(current []string) []string {
+	if g.Exclusive {
+		return g.Members
+	}
+	members := append([]string{}, current...)
+	added, _ := util.DiffNames(current, g.Members)
+	return append(members, added...)
+}
@@ -1,9 +1,11 @@
 package khan
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"runtime"
+	"sync"
 
 	"khan.rip/rio"
 )
@@ -20,7 +22,20 @@ type Host struct {
 	SSH  bool
 	Host string // Host for SSH
 
-	rh rio.Host
+	// From the inventory, if the host was selected from it
+	Groups []string
+	Vars   map[string]string
+
+	rh   rio.Host
+	live rio.Host // rh without the dry run wrapper, for shipping the agent
+
+	login string // who we log in (or run) as, before any become
+
+	packages pkgbatch
+
+	// pathlocks serializes items that read, change and write back a file
+	pathlocksmu sync.Mutex
+	pathlocks   map[string]*sync.Mutex
 }
 
 func (host *Host) Key() string {
@@ -29,6 +44,24 @@ func (host *Host) Key() string {
 	}
 	return "local"
 }
+
+// lockPath holds fpath until the returned func is called
+func (host *Host) lockPath(fpath string) func() {
+	host.pathlocksmu.Lock()
+	if host.pathlocks == nil {
+		host.pathlocks = map[string]*sync.Mutex{}
+	}
+	mu := host.pathlocks[fpath]
+	if mu == nil {
+		mu = &sync.Mutex{}
+		host.pathlocks[fpath] = mu
+	}
+	host.pathlocksmu.Unlock()
+
+	mu.Lock()
+	return mu.Unlock
+}
+
 func (host *Host) String() string {
 	return host.rh.String()
 }
@@ -51,8 +84,18 @@ func (host *Host) AddFromSource(source string, add ...Item) error {
 	return nil
 }
 
-func (host *Host) OS() (string, error) {
-	info, err := host.rh.Info()
+// InGroup reports whether the host is a member of an inventory group
+func (host *Host) InGroup(group string) bool {
+	for _, g := range host.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+func (host *Host) OS(ctx context.Context) (string, error) {
+	info, err := host.rh.Info(ctx)
 	if err != nil {
 		return "", err
 	}
@@ -60,6 +103,6 @@ func (host *Host) OS() (string, error) {
 }
 
 // Passthrough functions to rio.host
-func (host *Host) Open(path string) (io.ReadCloser, error) {
-	return host.rh.Open(path)
+func (host *Host) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	return host.rh.Open(ctx, path)
 }
@@ -0,0 +1,297 @@
+package khan
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"khan.rip/rio"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Inventory is the list of hosts khan knows about, from inventory.yaml in the
+// project root. It is parsed at khan build time and compiled in.
+//
+//	hosts:
+//	  web1:
+//	    address: web1.example.com
+//	    groups: [web]
+//	    vars:
+//	      role: frontend
+//	groups:
+//	  web:
+//	    user: deploy
+//	    become: sudo
+//	    vars:
+//	      http_port: 80
+type Inventory struct {
+	Hosts  []InventoryHost
+	Groups []InventoryGroup
+}
+
+type InventoryHost struct {
+	Name    string   `yaml:"-"`
+	Address string   `yaml:"address"` // Defaults to Name
+	User    string   `yaml:"user"`
+	Port    int      `yaml:"port"`
+	Groups  []string `yaml:"groups"`
+
+	// Become is "sudo" or "doas", to run as BecomeUser (default root)
+	Become     string `yaml:"become"`
+	BecomeUser string `yaml:"become_user"`
+
+	Vars map[string]string `yaml:"vars"`
+}
+
+// InventoryGroup connection parameters are defaults for member hosts. Vars
+// are merged in group order, and host vars override group vars.
+type InventoryGroup struct {
+	Name  string   `yaml:"-"`
+	User  string   `yaml:"user"`
+	Port  int      `yaml:"port"`
+	Hosts []string `yaml:"hosts"`
+
+	Become     string `yaml:"become"`
+	BecomeUser string `yaml:"become_user"`
+
+	Vars map[string]string `yaml:"vars"`
+}
+
+// Connect returns the SSH connect string (user@address:port)
+func (ih *InventoryHost) Connect() string {
+	s := ih.Address
+	if s == "" {
+		s = ih.Name
+	}
+	if ih.User != "" {
+		s = ih.User + "@" + s
+	}
+	if ih.Port != 0 {
+		s += ":" + strconv.Itoa(ih.Port)
+	}
+	return s
+}
+
+// Become returns the privilege escalation settings, or nil for none
+func (ih *InventoryHost) BecomeAs() *rio.Become {
+	if ih.Become == "" {
+		return nil
+	}
+	return &rio.Become{Method: ih.Become, User: ih.BecomeUser}
+}
+
+var inventory *Inventory
+
+// SetInventory is called from generated code when the project has an inventory.yaml
+func SetInventory(inv Inventory) {
+	inventory = &inv
+}
+
+func ParseInventory(buf []byte) (*Inventory, error) {
+	var raw struct {
+		Hosts  map[string]*InventoryHost  `yaml:"hosts"`
+		Groups map[string]*InventoryGroup `yaml:"groups"`
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(buf))
+	dec.KnownFields(true)
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	inv := &Inventory{}
+
+	for name, g := range raw.Groups {
+		if g == nil {
+			g = &InventoryGroup{}
+		}
+		if _, ok := raw.Hosts[name]; ok {
+			return nil, fmt.Errorf("Inventory name %#v is both a host and a group", name)
+		}
+		for _, h := range g.Hosts {
+			if _, ok := raw.Hosts[h]; !ok {
+				return nil, fmt.Errorf("Inventory group %#v has unknown host %#v", name, h)
+			}
+		}
+		if g.Become != "" {
+			b := rio.Become{Method: g.Become}
+			if err := b.Validate(); err != nil {
+				return nil, fmt.Errorf("Inventory group %#v: %w", name, err)
+			}
+		}
+		g.Name = name
+		inv.Groups = append(inv.Groups, *g)
+	}
+
+	for name, h := range raw.Hosts {
+		if h == nil {
+			h = &InventoryHost{}
+		}
+		for _, g := range h.Groups {
+			if _, ok := raw.Hosts[g]; ok {
+				return nil, fmt.Errorf("Inventory host %#v group %#v is a host", name, g)
+			}
+		}
+		if h.Become != "" {
+			b := rio.Become{Method: h.Become}
+			if err := b.Validate(); err != nil {
+				return nil, fmt.Errorf("Inventory host %#v: %w", name, err)
+			}
+		}
+		h.Name = name
+		inv.Hosts = append(inv.Hosts, *h)
+	}
+
+	sort.Slice(inv.Hosts, func(a, b int) bool {
+		return inv.Hosts[a].Name < inv.Hosts[b].Name
+	})
+	sort.Slice(inv.Groups, func(a, b int) bool {
+		return inv.Groups[a].Name < inv.Groups[b].Name
+	})
+
+	return inv, nil
+}
+
+func (inv *Inventory) group(name string) *InventoryGroup {
+	for i := range inv.Groups {
+		if inv.Groups[i].Name == name {
+			return &inv.Groups[i]
+		}
+	}
+	return nil
+}
+
+// resolve returns a copy of the host with group membership, connection
+// defaults and vars filled in.
+func (inv *Inventory) resolve(ih InventoryHost) InventoryHost {
+	seen := map[string]bool{}
+	var groups []string
+	for _, g := range ih.Groups {
+		if !seen[g] {
+			seen[g] = true
+			groups = append(groups, g)
+		}
+	}
+	for _, g := range inv.Groups {
+		if seen[g.Name] {
+			continue
+		}
+		for _, h := range g.Hosts {
+			if h == ih.Name {
+				seen[g.Name] = true
+				groups = append(groups, g.Name)
+				break
+			}
+		}
+	}
+
+	vars := map[string]string{}
+	for _, gname := range groups {
+		g := inv.group(gname)
+		if g == nil {
+			continue
+		}
+		if ih.User == "" {
+			ih.User = g.User
+		}
+		if ih.Port == 0 {
+			ih.Port = g.Port
+		}
+		if ih.Become == "" {
+			ih.Become = g.Become
+			ih.BecomeUser = g.BecomeUser
+		}
+		for k, v := range g.Vars {
+			vars[k] = v
+		}
+	}
+	for k, v := range ih.Vars {
+		vars[k] = v
+	}
+
+	ih.Groups = groups
+	ih.Vars = vars
+	return ih
+}
+
+func matchHostPattern(pattern, name string, groups []string) bool {
+	if pattern == "all" {
+		return true
+	}
+	if ok, _ := path.Match(pattern, name); ok {
+		return true
+	}
+	for _, g := range groups {
+		if ok, _ := path.Match(pattern, g); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Select picks hosts from the inventory. Each entry in hosts and limit is a
+// host name, group name or shell glob against either; "all" matches
+// everything. hosts is a union, and every entry must match something. limit
+// then narrows the selection down, and a "!" prefix excludes. An empty hosts
+// list with a limit starts from all hosts.
+func (inv *Inventory) Select(hosts, limit []string) ([]InventoryHost, error) {
+	var all []InventoryHost
+	for _, ih := range inv.Hosts {
+		all = append(all, inv.resolve(ih))
+	}
+
+	if len(hosts) == 0 && len(limit) > 0 {
+		hosts = []string{"all"}
+	}
+
+	selected := map[string]bool{}
+	for _, pattern := range hosts {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Bad host pattern %#v: %w", pattern, err)
+		}
+		matched := false
+		for i := range all {
+			if matchHostPattern(pattern, all[i].Name, all[i].Groups) {
+				selected[all[i].Name] = true
+				matched = true
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("No inventory hosts match %#v", pattern)
+		}
+	}
+
+	var r []InventoryHost
+	for i := range all {
+		ih := &all[i]
+		if !selected[ih.Name] {
+			continue
+		}
+		keep := true
+		hasinclude := false
+		included := false
+		for _, pattern := range limit {
+			if strings.HasPrefix(pattern, "!") {
+				if matchHostPattern(pattern[1:], ih.Name, ih.Groups) {
+					keep = false
+				}
+				continue
+			}
+			hasinclude = true
+			if matchHostPattern(pattern, ih.Name, ih.Groups) {
+				included = true
+			}
+		}
+		if hasinclude && !included {
+			keep = false
+		}
+		if keep {
+			r = append(r, *ih)
+		}
+	}
+	return r, nil
+}
@@ -1,6 +1,7 @@
 package khan
 
 import (
+	"context"
 	"fmt"
 	"runtime"
 )
@@ -61,7 +62,7 @@ type Item interface {
 	Clone() Item
 	String() string
 
-	Apply(host *Host) (Status, error)
+	Apply(ctx context.Context, host *Host) (Status, error)
 
 	Provides() []string
 	After() []string
@@ -75,6 +76,13 @@ type StaticFiler interface {
 	StaticFiles() []string
 }
 
+// OptionalAfterer is for items that order after a key only if something
+// provides it, e.g. the File managing a path they edit. Those After keys
+// aren't reported as unresolved.
+type OptionalAfterer interface {
+	OptionalAfter() []string
+}
+
 // Add to the default run context
 func Add(add ...Item) {
 	_, fn, line, _ := runtime.Caller(1)
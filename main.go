@@ -1,12 +1,16 @@
 package khan
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"net"
 	"os"
+	"os/signal"
+	"os/user"
 	"sort"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"khan.rip/rio"
@@ -14,11 +18,8 @@ import (
 	"khan.rip/rio/local"
 	"khan.rip/rio/remote"
 
-	"github.com/desops/sshpool"
 	"github.com/flosch/pongo2/v4"
 	"github.com/spf13/pflag"
-	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/agent"
 )
 
 var (
@@ -26,6 +27,10 @@ var (
 		meta:            map[int]*imeta{},
 		fences:          map[string]*sync.Mutex{},
 		befores:         map[string][]string{},
+		providers:       map[string]Item{},
+		notifies:        map[string]*notify{},
+		notifying:       map[int][]*notify{},
+		facts:           map[*Host]map[string]string{},
 		errors:          map[string]error{},
 		itemstatuscount: map[Status]int{},
 	}
@@ -47,6 +52,7 @@ func Apply() error {
 	r := defaultrun
 
 	r.assetfn = mainassetfn
+	r.assetnames = mainassetnames
 
 	r.pongocachefiles = map[string]*pongo2.Template{}
 	r.pongocachestrings = map[string]*pongo2.Template{}
@@ -60,101 +66,165 @@ func Apply() error {
 	pflag.BoolVarP(&r.Dry, "dry", "d", false, "Dry run; Don't make any changes")
 	pflag.BoolVarP(&r.Diff, "diff", "D", false, "Show full diff of file content changes")
 	pflag.BoolVarP(&r.Verbose, "verbose", "v", false, "Be more verbose")
+	pflag.BoolVar(&r.Strict, "strict", false, "Fail if an item depends on something nothing provides")
+
+	pflag.StringVar(&r.HostKeyCheck, "host-key-check", remote.HostKeyStrict, "SSH host key checking: strict, accept-new or off")
+	pflag.StringVar(&r.KnownHosts, "known-hosts", "", "SSH known hosts file (default ~/.ssh/known_hosts)")
+
+	var become rio.Become
+	pflag.StringVar(&become.Method, "become", "", "Run commands and file access through sudo or doas")
+	pflag.StringVar(&become.User, "become-user", "", "User to become (default root)")
 
 	localmode := false
 	pflag.BoolVarP(&localmode, "local", "l", false, "Run without SSH against local host as current user")
 
 	var hostlist []string
-	pflag.StringSliceVarP(&hostlist, "remote", "r", nil, "Run against remote host via SSH (user@host:port, may be repeated)")
+	pflag.StringSliceVarP(&hostlist, "remote", "r", nil, "Run against remote host via SSH (user@host:port or ssh config alias, may be repeated)")
+
+	var hostpatterns, limitpatterns []string
+	pflag.StringSliceVarP(&hostpatterns, "hosts", "H", nil, "Run against inventory hosts or groups (glob patterns, \"all\" for everything)")
+	pflag.StringSliceVar(&limitpatterns, "limit", nil, "Narrow inventory hosts down to patterns (prefix with ! to exclude)")
+
+	agentmode := false
+	pflag.BoolVar(&agentmode, "agent", false, "Copy this binary to remote hosts and run it there instead of over SSH")
+
+	// Set by the parent in agent mode: the host we are, as JSON
+	agentchild := ""
+	pflag.StringVar(&agentchild, "agent-child", "", "")
+	_ = pflag.CommandLine.MarkHidden("agent-child")
 
 	pflag.Parse()
 
+	// The command line wins over the inventory
+	var clibecome *rio.Become
+	if become.Method != "" {
+		if err := become.Validate(); err != nil {
+			return err
+		}
+		clibecome = &become
+	} else if become.User != "" {
+		return fmt.Errorf("--become-user needs --become sudo or --become doas")
+	}
+
+	defer func() {
+		if r.ssh != nil {
+			r.ssh.close()
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.ctx = ctx
+
+	// First interrupt stops scheduling and lets deferred host cleanup run.
+	// After that, signals get their default behavior back so a second
+	// Ctrl-C kills us outright.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-sigs:
+			signal.Stop(sigs)
+			fmt.Fprintf(os.Stderr, "%s─── %s: finishing up, interrupt again to kill ───%s\n", color(Yellow), sig, reset())
+			cancel()
+		case <-ctx.Done():
+			signal.Stop(sigs)
+		}
+	}()
+
+	if agentchild != "" {
+		localmode = true
+		r.out = &outputter{events: json.NewEncoder(os.Stdout)}
+	}
+
 	if localmode {
 		hostname, err := os.Hostname()
 		if err != nil {
 			return err
 		}
-		rh := rio.Host(local.New())
+		lh := local.New()
+		if clibecome != nil {
+			lh.SetBecome(clibecome)
+		}
+		rh := rio.Host(lh)
 		if r.Dry {
-			rh = rio.Host(dry.New(uint32(os.Geteuid()), uint32(os.Getegid()), rh))
+			uid, gid := os.Geteuid(), os.Getegid()
+			if clibecome != nil && (clibecome.User == "" || clibecome.User == "root") {
+				uid, gid = 0, 0
+			}
+			rh = rio.Host(dry.New(uint32(uid), uint32(gid), rh))
 		}
 
-		r.Hosts = append(r.Hosts, &Host{
+		host := &Host{
 			Verbose: r.Verbose,
 
-			Name: hostname,
-			SSH:  false,
-			Run:  r,
-			rh:   rh,
-		})
+			Name:  hostname,
+			SSH:   false,
+			Run:   r,
+			rh:    rh,
+			live:  lh,
+			login: localLogin(),
+		}
+		if agentchild != "" {
+			if err := agentChild(host, agentchild); err != nil {
+				return err
+			}
+		}
+		r.Hosts = append(r.Hosts, host)
 
 		defer rh.Cleanup()
 	}
 
 	for _, h := range hostlist {
-		if r.Pool == nil {
-			// initialize SSH pool
-			socket := os.Getenv("SSH_AUTH_SOCK")
-			conn, err := net.Dial("unix", socket)
-			if err != nil {
-				return fmt.Errorf("Failed to open SSH_AUTH_SOCK: %w", err)
-			}
-			agentClient := agent.NewClient(conn)
-			sshconfig := &ssh.ClientConfig{
-				Auth: []ssh.AuthMethod{
-					ssh.PublicKeysCallback(agentClient.Signers),
-				},
-				HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-					// TODO
-					return nil
-				},
-				BannerCallback: ssh.BannerDisplayStderr(),
-			}
-
-			r.Pool = sshpool.New(sshconfig, &sshpool.PoolConfig{MaxConnections: 10, MaxSessions: 10, Debug: r.Verbose})
-		}
 		name := h
 		if i := strings.IndexByte(name, ':'); i > -1 {
 			name = name[:i]
 		}
+		host, err := r.addRemoteHost(name, h, clibecome)
+		if err != nil {
+			return err
+		}
+		defer host.rh.Cleanup()
+	}
 
-		rh := rio.Host(remote.New(r.Pool, h))
-		if r.Verbose {
-			rh.SetVerbose()
+	if len(hostpatterns) > 0 || len(limitpatterns) > 0 {
+		if inventory == nil {
+			return fmt.Errorf("--hosts and --limit need an inventory.yaml in the project root")
 		}
-		if r.Dry {
-			// This uid/gid guess is incorrect. TODO: Concurrently SSH to all the hosts and
-			// get this info correctly. This could double-serve as a pool warmup :)
-			uid := os.Geteuid()
-			gid := os.Getegid()
-			at := strings.IndexByte(h, '@')
-			if at > -1 && h[:at] == "root" {
-				uid = 0
-				gid = 0
+		selected, err := inventory.Select(hostpatterns, limitpatterns)
+		if err != nil {
+			return err
+		}
+		for _, ih := range selected {
+			b := clibecome
+			if b == nil {
+				b = ih.BecomeAs()
 			}
-			rh = rio.Host(dry.New(uint32(uid), uint32(gid), rh))
-			if r.Verbose {
-				rh.SetVerbose()
+			host, err := r.addRemoteHost(ih.Name, ih.Connect(), b)
+			if err != nil {
+				return err
 			}
+			host.Groups = ih.Groups
+			host.Vars = ih.Vars
+			defer host.rh.Cleanup()
 		}
-
-		r.Hosts = append(r.Hosts, &Host{
-			Verbose: r.Verbose,
-			Name:    name,
-			SSH:     true,
-			Host:    h,
-			Run:     r,
-			rh:      rh,
-		})
-
-		defer rh.Cleanup()
 	}
 
 	if len(r.Hosts) == 0 {
-		fmt.Println("Nothing to do: No remote hosts (-h/--host) or local host (-l/--local) were specified")
+		fmt.Println("Nothing to do: No remote hosts (-r/--remote, -H/--hosts) or local host (-l/--local) were specified")
 		return nil
 	}
 
+	if agentchild != "" {
+		err := r.agentChildRun()
+		r.out.emit(&outevent{Event: "done", Err: errstr(err)})
+		return err
+	}
+
+	if r.out == nil {
+		r.out = &outputter{showhost: len(r.Hosts) > 1}
+	}
+
 	decorate := Color{Bold: true}.String() + "khan" + reset()
 
 	title := decorate + " "
@@ -179,16 +249,41 @@ func Apply() error {
 	fmt.Println(title)
 
 	for _, host := range r.Hosts {
-		_, err := host.rh.User("root")
+		_, err := host.rh.User(ctx, "root")
 		if err != nil {
-			return err
+			return fmt.Errorf("%s: %w", host.Name, err)
 		}
 	}
 
-	if err := r.run(); err != nil {
+	// In agent mode remote hosts run their own items; only the rest are ours
+	var agents []*Host
+	if agentmode {
+		var rest []*Host
+		for _, host := range r.Hosts {
+			if host.SSH {
+				agents = append(agents, host)
+			} else {
+				rest = append(rest, host)
+			}
+		}
+		r.Hosts = rest
+	}
+
+	if len(agents) > 0 {
+		// Before runinit, which only keeps items for the hosts left here
+		r.agentenv = r.agentEnv()
+	}
+	if err := r.runinit(); err != nil {
 		return err
 	}
 
+	var runerr error
+	if len(agents) > 0 {
+		runerr = r.runWithAgents(agents)
+	} else {
+		runerr = r.run()
+	}
+
 	var summarychunks []string
 	var statuses []Status
 	for k := range r.itemstatuscount {
@@ -202,7 +297,7 @@ func Apply() error {
 	})
 	for _, status := range statuses {
 		count := r.itemstatuscount[status]
-		if count > 0 {
+		if count > 0 && status != InvalidStatus {
 			summarychunks = append(summarychunks, fmt.Sprintf("%s%d %s%s", status.Color(), count, status, reset()))
 		}
 	}
@@ -213,11 +308,97 @@ func Apply() error {
 
 	dur := time.Since(tstart)
 
-	fmt.Printf("%s %d items %sin %s\n",
+	interrupted := ""
+	if ctx.Err() != nil {
+		interrupted = color(Yellow) + "interrupted " + reset()
+	}
+
+	fmt.Printf("%s %d items %s%sin %s\n",
 		decorate,
 		r.itemsuccesscount,
 		summarystr,
+		interrupted,
 		color_duration(dur).Wrap(format_duration(dur)),
 	)
-	return nil
+	return runerr
+}
+
+// localLogin is who's running us, from before sudo or doas if they were used
+func localLogin() string {
+	for _, env := range []string{"SUDO_USER", "DOAS_USER"} {
+		if name := os.Getenv(env); name != "" {
+			return name
+		}
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
+
+// addRemoteHost sets up a host reached over SSH. connect is user@host:port,
+// or anything else ~/.ssh/config knows about. become may be nil.
+func (r *Run) addRemoteHost(name, connect string, become *rio.Become) (*Host, error) {
+	if r.ssh == nil {
+		e, err := newsshenv(r)
+		if err != nil {
+			return nil, err
+		}
+		r.ssh = e
+	}
+
+	for _, host := range r.Hosts {
+		if host.SSH && host.Host == connect {
+			return nil, fmt.Errorf("Host %s selected more than once", connect)
+		}
+	}
+
+	pool, poolconnect, dest, err := r.ssh.pool(connect)
+	if err != nil {
+		return nil, err
+	}
+
+	remotehost := remote.New(pool, poolconnect)
+	remotehost.SetName(connect)
+	if become != nil {
+		remotehost.SetBecome(become)
+	}
+	rh := rio.Host(remotehost)
+	if r.Verbose {
+		rh.SetVerbose()
+	}
+	if r.Dry {
+		// This uid/gid guess is incorrect. TODO: Concurrently SSH to all the hosts and
+		// get this info correctly. This could double-serve as a pool warmup :)
+		uid := os.Geteuid()
+		gid := os.Getegid()
+		user := dest.User
+		if become != nil {
+			user = become.User
+			if user == "" {
+				user = "root"
+			}
+		}
+		if user == "root" {
+			uid = 0
+			gid = 0
+		}
+		rh = rio.Host(dry.New(uint32(uid), uint32(gid), rh))
+		if r.Verbose {
+			rh.SetVerbose()
+		}
+	}
+
+	host := &Host{
+		Verbose: r.Verbose,
+		Name:    name,
+		SSH:     true,
+		Host:    connect,
+		Run:     r,
+		rh:      rh,
+		live:    remotehost,
+		login:   dest.User,
+	}
+	r.Hosts = append(r.Hosts, host)
+	return host, nil
 }
@@ -0,0 +1,148 @@
+package khan
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Notifier is implemented by items that trigger a handler when they change.
+// Each entry is the key the handler provides, optionally followed by
+// "#trigger". For example "service:nginx#reload".
+type Notifier interface {
+	Notifies() []string
+}
+
+// Handler is implemented by items that can be triggered by changes in other
+// items. Handle is called at most once per host and run, after the handler
+// item and all of its notifiers have finished, and only if at least one
+// notifier changed something. A blank trigger means the handler's default.
+type Handler interface {
+	Handle(ctx context.Context, host *Host, triggers []string) (Status, error)
+}
+
+// notify is the internal item scheduled for each handler with notifiers on a host.
+type notify struct {
+	key      string
+	triggers []string
+
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	changed bool
+
+	id int
+}
+
+func splitNotify(s string) (string, string) {
+	if i := strings.LastIndexByte(s, '#'); i > -1 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+func (n *notify) String() string {
+	triggers := strings.Join(n.triggers, ",")
+	if triggers == "" {
+		return n.key
+	}
+	return n.key + " (" + triggers + ")"
+}
+
+func (n *notify) SetID(id int) {
+	n.id = id
+}
+func (n *notify) ID() int {
+	return n.id
+}
+func (n *notify) Clone() Item {
+	panic("notify items are created per host and cannot be cloned")
+}
+
+func (n *notify) After() []string {
+	return []string{n.key}
+}
+func (n *notify) Before() []string {
+	return nil
+}
+func (n *notify) Provides() []string {
+	return nil
+}
+
+// always have itemsmu locked before calling this
+func (n *notify) addtrigger(trigger string) {
+	for _, t := range n.triggers {
+		if t == trigger {
+			return
+		}
+	}
+	n.triggers = append(n.triggers, trigger)
+	sort.Strings(n.triggers)
+}
+
+// finish is called by the run loop when a notifier is done.
+func (n *notify) finish(status Status, err error) {
+	if err == nil && (status == Created || status == Modified || status == Deleted) {
+		n.mu.Lock()
+		n.changed = true
+		n.mu.Unlock()
+	}
+	n.wg.Done()
+}
+
+func (n *notify) Apply(ctx context.Context, host *Host) (Status, error) {
+	// Notifiers can't be interrupted mid-wait, so check on the way out.
+	n.wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n.mu.Lock()
+	changed := n.changed
+	n.mu.Unlock()
+
+	if !changed {
+		return Unchanged, nil
+	}
+
+	host.Run.itemsmu.Lock()
+	item := host.Run.providers[host.Key()+"-"+n.key]
+	triggers := make([]string, len(n.triggers))
+	copy(triggers, n.triggers)
+	host.Run.itemsmu.Unlock()
+
+	if item == nil {
+		return 0, fmt.Errorf("Nothing provides %#v to notify", n.key)
+	}
+
+	h, ok := item.(Handler)
+	if !ok {
+		return 0, fmt.Errorf("%T %v does not accept notifications", item, item)
+	}
+
+	return h.Handle(ctx, host, triggers)
+}
+
+// always have itemsmu locked before calling this
+func (r *Run) addNotifies(host *Host, source string, item Item, notifier Notifier) error {
+	for _, target := range notifier.Notifies() {
+		key, trigger := splitNotify(target)
+
+		nk := host.Key() + "-" + key
+		n, ok := r.notifies[nk]
+		if !ok {
+			n = &notify{
+				key: key,
+			}
+			r.notifies[nk] = n
+			if err := r.addHostItem(host, source, n); err != nil {
+				return err
+			}
+		}
+		n.addtrigger(trigger)
+		n.wg.Add(1)
+		r.notifying[item.ID()] = append(r.notifying[item.ID()], n)
+	}
+	return nil
+}
@@ -1,47 +1,105 @@
 package khan
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 )
 
 type outputter struct {
+	// showhost adds the host name to each line, for runs on more than one host
+	showhost bool
+
+	// events is set in agent children: results go back to the parent as
+	// JSON lines instead of being printed.
+	eventsmu sync.Mutex
+	events   *json.Encoder
+}
+
+// outevent is one line of the agent result stream
+type outevent struct {
+	Event  string `json:"event"` // "active", "finish" or "done"
+	Type   string `json:"type,omitempty"`
+	Item   string `json:"item,omitempty"`
+	Status Status `json:"status,omitempty"`
+	Nanos  int64  `json:"ns,omitempty"`
+	Err    string `json:"err,omitempty"`
+}
+
+func (o *outputter) emit(ev *outevent) {
+	o.eventsmu.Lock()
+	defer o.eventsmu.Unlock()
+	_ = o.events.Encode(ev)
+}
+
+func itemtype(item Item) string {
+	return strings.ToLower(strings.TrimPrefix(fmt.Sprintf("%T", item), "*khan."))
+}
+
+func (o *outputter) hostsuffix(host string) string {
+	if !o.showhost || host == "" {
+		return ""
+	}
+	return " " + Color{Dim: true}.Wrap("on "+host)
 }
 
 func (o *outputter) Active(r *Run, item Item, status Status) {
+	if o.events != nil {
+		o.emit(&outevent{Event: "active", Type: itemtype(item), Item: item.String(), Status: status})
+		return
+	}
+	o.active(r.itemhost(item), itemtype(item), item.String(), status)
+}
+
+func (o *outputter) active(host, typ, name string, status Status) {
 	color := status.Color()
 
 	boldcolor := color
 	boldcolor.Bold = true
 
-	typ := strings.ToLower(strings.TrimPrefix(fmt.Sprintf("%T", item), "*khan."))
-
-	fmt.Println(boldcolor.Wrap(fmt.Sprintf("%8s %s", status.ActiveString(), typ)) + " " + color.Wrap(item.String()))
+	fmt.Println(boldcolor.Wrap(fmt.Sprintf("%8s %s", status.ActiveString(), typ)) + " " + color.Wrap(name) + o.hostsuffix(host))
 }
+
 func (o *outputter) FinishItem(start time.Time, r *Run, item Item, status Status, err error) {
-	if err == nil && status == Unchanged && !r.Verbose {
+	d := time.Since(start)
+
+	if o.events != nil {
+		ev := &outevent{Event: "finish", Type: itemtype(item), Item: item.String(), Status: status, Nanos: int64(d)}
+		if err != nil {
+			ev.Err = err.Error()
+		}
+		o.emit(ev)
 		return
 	}
 
-	d := time.Since(start)
+	errstr := ""
+	if err != nil {
+		errstr = err.Error()
+	}
+	o.finish(r, r.itemhost(item), itemtype(item), item.String(), status, d, errstr)
+}
 
-	typ := strings.ToLower(strings.TrimPrefix(fmt.Sprintf("%T", item), "*khan."))
+func (o *outputter) finish(r *Run, host, typ, name string, status Status, d time.Duration, errstr string) {
+	if errstr == "" && status == Unchanged && !r.Verbose {
+		return
+	}
 
 	color := status.Color()
-	if err != nil {
+	if errstr != "" {
 		color.Color = Red
 	}
 
 	boldcolor := color
 	boldcolor.Bold = true
 
-	msg := boldcolor.Wrap(fmt.Sprintf("%8s %s", status.String(), typ)) + " " + color.Wrap(item.String())
+	msg := boldcolor.Wrap(fmt.Sprintf("%8s %s", status.String(), typ)) + " " + color.Wrap(name) + o.hostsuffix(host)
 
 	msg += " in " + color_duration(d).Wrap(format_duration(d))
 
-	if err != nil {
-		msg += " failed: " + err.Error()
+	if errstr != "" {
+		msg += " failed: " + errstr
 	}
 
 	//	if o.bar != nil {
@@ -50,7 +108,6 @@ func (o *outputter) FinishItem(start time.Time, r *Run, item Item, status Status
 	//	}
 
 	fmt.Println(msg)
-	_ = msg
 }
 
 func (o *outputter) Flush() {
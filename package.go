@@ -1,5 +1,211 @@
 package khan
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"khan.rip/rio"
+	"khan.rip/rio/util"
+)
+
 type Package struct {
-	Name string
+	Name string `khan:"name,shortvalue"`
+
+	// Version pins an exact package version. Leave blank for any version.
+	// On dnf hosts it's either VERSION or VERSION-RELEASE.
+	Version string
+
+	Delete bool
+
+	// Notify lists handlers to trigger when this item changes, e.g. "service:nginx#reload"
+	Notify []string
+
+	// Limits which hosts get this item
+	Target
+
+	id int
+}
+
+func (p *Package) String() string {
+	if p.Version != "" {
+		return fmt.Sprintf("%s/%s", p.Name, p.Version)
+	}
+	return p.Name
+}
+
+func (p *Package) SetID(id int) {
+	p.id = id
+}
+func (p *Package) ID() int {
+	return p.id
+}
+func (p *Package) Clone() Item {
+	r := *p
+	r.id = 0
+	return &r
+}
+
+func (p *Package) Validate() error {
+	if p.Name == "" {
+		return errors.New("Package name is required")
+	}
+	if p.Delete && p.Version != "" {
+		return errors.New("Package version cannot be set when deleting")
+	}
+	return nil
+}
+
+func (p *Package) StaticFiles() []string {
+	return nil
+}
+
+func (p *Package) After() []string {
+	return nil
+}
+func (p *Package) Before() []string {
+	return nil
+}
+func (p *Package) Provides() []string {
+	if p.Delete {
+		return []string{"-package:" + p.Name}
+	} else {
+		return []string{"package:" + p.Name}
+	}
+}
+func (p *Package) Notifies() []string {
+	return p.Notify
+}
+
+func (p *Package) Apply(ctx context.Context, host *Host) (Status, error) {
+	old, err := host.rh.Package(ctx, p.Name)
+	if err != nil {
+		return 0, err
+	}
+
+	if p.Delete {
+		if old == nil {
+			return Unchanged, nil
+		}
+		host.Run.out.Active(host.Run, p, Deleted)
+		if err := host.packages.run(ctx, host, &pkgrequest{name: p.Name, remove: true}); err != nil {
+			return 0, err
+		}
+		return Deleted, nil
+	}
+
+	if old != nil {
+		if p.Version == "" {
+			return Unchanged, nil
+		}
+		info, err := host.rh.Info(ctx)
+		if err != nil {
+			return 0, err
+		}
+		pm, err := util.PackageManager(info)
+		if err != nil {
+			return 0, err
+		}
+		if util.PackageVersionMatches(pm, p.Version, old.Version) {
+			return Unchanged, nil
+		}
+	}
+
+	status := Created
+	if old != nil {
+		status = Modified
+	}
+	host.Run.out.Active(host.Run, p, status)
+
+	if err := host.packages.run(ctx, host, &pkgrequest{name: p.Name, version: p.Version}); err != nil {
+		return 0, err
+	}
+	return status, nil
+}
+
+// pkgbatch collects package changes for one host. Package managers hold an
+// exclusive lock, so only one batch runs at a time. Whatever queues up while
+// a batch is running goes out together in the next one.
+type pkgbatch struct {
+	mu sync.Mutex // held while the package manager runs
+
+	queuemu sync.Mutex
+	queue   []*pkgrequest
+}
+
+type pkgrequest struct {
+	name    string
+	version string
+	remove  bool
+
+	done chan error
+}
+
+func (b *pkgbatch) run(ctx context.Context, host *Host, req *pkgrequest) error {
+	req.done = make(chan error, 1)
+
+	b.queuemu.Lock()
+	b.queue = append(b.queue, req)
+	b.queuemu.Unlock()
+
+	b.mu.Lock()
+
+	b.queuemu.Lock()
+	batch := b.queue
+	b.queue = nil
+	b.queuemu.Unlock()
+
+	// Another caller may have already run our request in its batch.
+	if len(batch) > 0 {
+		var installs, removes []*pkgrequest
+		for _, r := range batch {
+			if r.remove {
+				removes = append(removes, r)
+			} else {
+				installs = append(installs, r)
+			}
+		}
+		b.apply(ctx, host, installs, false)
+		b.apply(ctx, host, removes, true)
+	}
+
+	b.mu.Unlock()
+
+	return <-req.done
+}
+
+func (b *pkgbatch) apply(ctx context.Context, host *Host, reqs []*pkgrequest, remove bool) {
+	if len(reqs) == 0 {
+		return
+	}
+
+	exec := func(reqs []*pkgrequest) error {
+		if remove {
+			names := make([]string, len(reqs))
+			for i, r := range reqs {
+				names[i] = r.name
+			}
+			return host.rh.RemovePackages(ctx, names)
+		}
+		pkgs := make([]*rio.Package, len(reqs))
+		for i, r := range reqs {
+			pkgs[i] = &rio.Package{Name: r.name, Version: r.version}
+		}
+		return host.rh.InstallPackages(ctx, pkgs)
+	}
+
+	err := exec(reqs)
+	if err == nil || len(reqs) == 1 {
+		for _, r := range reqs {
+			r.done <- err
+		}
+		return
+	}
+
+	// One bad package fails the whole batch. Retry one at a time so the
+	// error lands on the item that caused it.
+	for _, r := range reqs {
+		r.done <- exec([]*pkgrequest{r})
+	}
 }
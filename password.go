@@ -0,0 +1,195 @@
+package khan
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// PasswordSHA512 is glibc's "$6$" crypt, the default
+	PasswordSHA512 = "sha512"
+	// PasswordBcrypt is "$2a$" bcrypt, as OpenBSD uses
+	PasswordBcrypt = "bcrypt"
+)
+
+// CryptPassword hashes a password for /etc/shadow (or master.passwd) with
+// a fresh random salt
+func CryptPassword(plain, scheme string) (string, error) {
+	switch scheme {
+	case "", PasswordSHA512:
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		for i, b := range salt {
+			salt[i] = cryptAlphabet[b%64]
+		}
+		return sha512Crypt(plain, string(salt), 5000, false), nil
+	case PasswordBcrypt:
+		if len(plain) > 72 {
+			return "", errors.New("bcrypt only uses the first 72 bytes of a password")
+		}
+		crypt, err := bcrypt.GenerateFromPassword([]byte(plain), bcrypt.DefaultCost)
+		return string(crypt), err
+	}
+	return "", fmt.Errorf("Unknown password scheme %#v: Expected %#v or %#v", scheme, PasswordSHA512, PasswordBcrypt)
+}
+
+// VerifyPassword checks plain against a crypt string. Schemes it doesn't
+// know (like yescrypt) never match.
+func VerifyPassword(plain, crypt string) bool {
+	switch {
+	case strings.HasPrefix(crypt, "$6$"):
+		rest := crypt[3:]
+		rounds, custom := 5000, false
+		if strings.HasPrefix(rest, "rounds=") {
+			end := strings.IndexByte(rest, '$')
+			if end == -1 {
+				return false
+			}
+			n, err := strconv.Atoi(rest[len("rounds="):end])
+			if err != nil {
+				return false
+			}
+			rounds, custom = n, true
+			rest = rest[end+1:]
+		}
+		end := strings.IndexByte(rest, '$')
+		if end == -1 {
+			return false
+		}
+		want := sha512Crypt(plain, rest[:end], rounds, custom)
+		return subtle.ConstantTimeCompare([]byte(want), []byte(crypt)) == 1
+	case verifiable(crypt):
+		// One of the bcrypts
+		return bcrypt.CompareHashAndPassword([]byte(crypt), []byte(plain)) == nil
+	}
+	return false
+}
+
+// verifiable is whether VerifyPassword knows crypt's scheme
+func verifiable(crypt string) bool {
+	for _, prefix := range []string{"$6$", "$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(crypt, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// sha512Crypt is Ulrich Drepper's SHA-crypt, as glibc's crypt(3) does "$6$"
+func sha512Crypt(plain, salt string, rounds int, custom bool) string {
+	key := []byte(plain)
+	if len(salt) > 16 {
+		salt = salt[:16]
+	}
+	if rounds < 1000 {
+		rounds = 1000
+	} else if rounds > 999999999 {
+		rounds = 999999999
+	}
+
+	b := sha512.New()
+	b.Write(key)
+	b.Write([]byte(salt))
+	b.Write(key)
+	bsum := b.Sum(nil)
+
+	a := sha512.New()
+	a.Write(key)
+	a.Write([]byte(salt))
+	i := len(key)
+	for ; i > 64; i -= 64 {
+		a.Write(bsum)
+	}
+	a.Write(bsum[:i])
+	for i := len(key); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			a.Write(bsum)
+		} else {
+			a.Write(key)
+		}
+	}
+	c := a.Sum(nil)
+
+	dp := sha512.New()
+	for range key {
+		dp.Write(key)
+	}
+	p := repeatBytes(dp.Sum(nil), len(key))
+
+	ds := sha512.New()
+	for i := 0; i < 16+int(c[0]); i++ {
+		ds.Write([]byte(salt))
+	}
+	s := repeatBytes(ds.Sum(nil), len(salt))
+
+	for r := 0; r < rounds; r++ {
+		h := sha512.New()
+		if r&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(c)
+		}
+		if r%3 != 0 {
+			h.Write(s)
+		}
+		if r%7 != 0 {
+			h.Write(p)
+		}
+		if r&1 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(p)
+		}
+		c = h.Sum(nil)
+	}
+
+	out := &strings.Builder{}
+	out.WriteString("$6$")
+	if custom {
+		fmt.Fprintf(out, "rounds=%d$", rounds)
+	}
+	out.WriteString(salt)
+	out.WriteByte('$')
+
+	b64 := func(b2, b1, b0 byte, n int) {
+		w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+		for ; n > 0; n-- {
+			out.WriteByte(cryptAlphabet[w&0x3f])
+			w >>= 6
+		}
+	}
+	for _, t := range sha512CryptOrder {
+		b64(c[t[0]], c[t[1]], c[t[2]], 4)
+	}
+	b64(0, 0, c[63], 2)
+	return out.String()
+}
+
+// sha512CryptOrder is how the final digest's bytes are shuffled into base 64
+var sha512CryptOrder = [21][3]int{
+	{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+	{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+	{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+	{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+	{62, 20, 41},
+}
+
+// repeatBytes is sum repeated out to n bytes
+func repeatBytes(sum []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out)+len(sum) <= n {
+		out = append(out, sum...)
+	}
+	return append(out, sum[:n-len(out)]...)
+}
@@ -0,0 +1,86 @@
+package khan
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Test vectors from Ulrich Drepper's SHA-crypt spec, as glibc checks them
+var sha512CryptTests = []struct {
+	plain, crypt string
+}{
+	{"Hello world!", "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"},
+	{"Hello world!", "$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v."},
+	{"This is just a test", "$6$rounds=5000$toolongsaltstrin$lQ8jolhgVRVhY4b5pZKaysCLi0QBxGoNeKQzQ3glMhwllF7oGDZxUhx1yxdYcz/e1JSbq3y6JMxxl8audkUEm0"},
+}
+
+func TestSHA512Crypt(t *testing.T) {
+	if got := sha512Crypt("Hello world!", "saltstring", 5000, false); got != sha512CryptTests[0].crypt {
+		t.Errorf("sha512Crypt saltstring = %s", got)
+	}
+	if got := sha512Crypt("Hello world!", "saltstringsaltstring", 10000, true); got != sha512CryptTests[1].crypt {
+		t.Errorf("sha512Crypt rounds=10000 = %s", got)
+	}
+	for _, test := range sha512CryptTests {
+		if !VerifyPassword(test.plain, test.crypt) {
+			t.Errorf("VerifyPassword(%#v, %s) = false", test.plain, test.crypt)
+		}
+		if VerifyPassword(test.plain+"x", test.crypt) {
+			t.Errorf("VerifyPassword(%#v, %s) = true", test.plain+"x", test.crypt)
+		}
+	}
+}
+
+func TestCryptPassword(t *testing.T) {
+	for _, scheme := range []string{PasswordSHA512, PasswordBcrypt} {
+		crypt, err := CryptPassword("hunter2", scheme)
+		if err != nil {
+			t.Fatalf("%s: %v", scheme, err)
+		}
+		if !VerifyPassword("hunter2", crypt) {
+			t.Errorf("%s: VerifyPassword(%s) = false", scheme, crypt)
+		}
+		if VerifyPassword("hunter3", crypt) {
+			t.Errorf("%s: VerifyPassword(%s) with the wrong password = true", scheme, crypt)
+		}
+
+		again, err := CryptPassword("hunter2", scheme)
+		if err != nil {
+			t.Fatalf("%s: %v", scheme, err)
+		}
+		if again == crypt {
+			t.Errorf("%s: same salt twice: %s", scheme, crypt)
+		}
+	}
+}
+
+func TestBcryptRoundTrip(t *testing.T) {
+	crypt, err := CryptPassword("correct horse", PasswordBcrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(crypt), []byte("correct horse")); err != nil {
+		t.Errorf("x/crypto/bcrypt rejects %s: %v", crypt, err)
+	}
+	if cost, err := bcrypt.Cost([]byte(crypt)); err != nil || cost != bcrypt.DefaultCost {
+		t.Errorf("bcrypt.Cost(%s) = %d, %v", crypt, cost, err)
+	}
+
+	// And x/crypto's own hashes check out here
+	theirs, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyPassword("correct horse", string(theirs)) {
+		t.Errorf("VerifyPassword(%s) = false", theirs)
+	}
+
+	long := make([]byte, 73)
+	for i := range long {
+		long[i] = 'a'
+	}
+	if _, err := CryptPassword(string(long), PasswordBcrypt); err == nil {
+		t.Error("73 byte bcrypt password didn't error")
+	}
+}
@@ -0,0 +1,172 @@
+package khan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// PurgeAccounts deletes local users and groups with ids in MinID-MaxID that
+// no User or Group item in the run is for. It runs after all of them.
+// With --dry it lists what would go.
+type PurgeAccounts struct {
+	// MinID and MaxID bound the uids and gids to purge, default 1000-59999
+	MinID uint32 `khan:"min_id"`
+	MaxID uint32 `khan:"max_id"`
+
+	// Keep lists users and groups to leave alone even if unmanaged
+	Keep []string
+
+	// Limits which hosts get this item
+	Target
+
+	id int
+}
+
+func (p *PurgeAccounts) String() string {
+	min, max := p.bounds()
+	return fmt.Sprintf("%d-%d", min, max)
+}
+
+func (p *PurgeAccounts) SetID(id int) {
+	p.id = id
+}
+func (p *PurgeAccounts) ID() int {
+	return p.id
+}
+func (p *PurgeAccounts) Clone() Item {
+	r := *p
+	r.id = 0
+	return &r
+}
+
+func (p *PurgeAccounts) Validate() error {
+	min, max := p.bounds()
+	if max < min {
+		return fmt.Errorf("PurgeAccounts max_id %d is below min_id %d", max, min)
+	}
+	for _, name := range p.Keep {
+		if name == "" {
+			return errors.New("PurgeAccounts keep has a blank name")
+		}
+	}
+	return nil
+}
+
+func (p *PurgeAccounts) bounds() (uint32, uint32) {
+	min, max := p.MinID, p.MaxID
+	if min == 0 {
+		min = 1000
+	}
+	if max == 0 {
+		max = 59999
+	}
+	return min, max
+}
+
+func (p *PurgeAccounts) After() []string {
+	return []string{"user:*", "-user:*", "group:*", "-group:*"}
+}
+func (p *PurgeAccounts) Before() []string {
+	return nil
+}
+func (p *PurgeAccounts) Provides() []string {
+	return []string{"purge:accounts"}
+}
+
+func (p *PurgeAccounts) Apply(ctx context.Context, host *Host) (Status, error) {
+	min, max := p.bounds()
+	users, groups := host.Run.managedAccounts(host)
+	for _, name := range p.Keep {
+		users[name] = true
+		groups[name] = true
+	}
+
+	// Never the users this run logs in and works as
+	info, err := host.rh.Info(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, name := range []string{info.User, host.login} {
+		if name != "" {
+			users[name] = true
+		}
+	}
+
+	status := Unchanged
+
+	list, err := host.rh.Users(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, user := range list {
+		if user.Uid < min || user.Uid > max || users[user.Name] {
+			continue
+		}
+		status = Deleted
+		host.Run.out.Active(host.Run, host.Run.addInner(host, p, &User{Name: user.Name, Uid: user.Uid}), Deleted)
+		if err := host.rh.DeleteUser(ctx, user.Name, false); err != nil {
+			return 0, err
+		}
+	}
+
+	// Groups still somebody's primary group stay. Deleting a user usually
+	// takes its own group along, so look again.
+	list, err = host.rh.Users(ctx)
+	if err != nil {
+		return 0, err
+	}
+	primary := map[string]bool{}
+	for _, user := range list {
+		primary[user.Group] = true
+	}
+
+	glist, err := host.rh.Groups(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, group := range glist {
+		if group.Gid < min || group.Gid > max || groups[group.Name] || primary[group.Name] {
+			continue
+		}
+		status = Deleted
+		host.Run.out.Active(host.Run, host.Run.addInner(host, p, &Group{Name: group.Name, Gid: group.Gid}), Deleted)
+		if err := host.rh.DeleteGroup(ctx, group.Name); err != nil {
+			return 0, err
+		}
+	}
+
+	return status, nil
+}
+
+// managedAccounts returns the users and groups that User and Group items on
+// host are for, deleted ones included
+func (r *Run) managedAccounts(host *Host) (map[string]bool, map[string]bool) {
+	r.itemsmu.Lock()
+	defer r.itemsmu.Unlock()
+
+	users, groups := map[string]bool{}, map[string]bool{}
+	for _, item := range r.items {
+		if im := r.meta[item.ID()]; im == nil || im.host != host {
+			continue
+		}
+		switch v := item.(type) {
+		case *User:
+			users[v.Name] = true
+			if v.Delete {
+				continue
+			}
+			if v.Group != "" {
+				groups[v.Group] = true
+			} else {
+				groups[v.Name] = true
+			}
+			for _, name := range v.Groups {
+				groups[name] = true
+			}
+		case *Group:
+			groups[v.Name] = true
+		}
+	}
+	return users, groups
+}
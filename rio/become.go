@@ -0,0 +1,57 @@
+package rio
+
+import (
+	"fmt"
+
+	"github.com/keegancsmith/shell"
+)
+
+const (
+	BecomeSudo = "sudo"
+	BecomeDoas = "doas"
+)
+
+// Become runs commands and file access as another user through sudo or
+// doas. It never prompts: the login user needs passwordless (NOPASSWD or
+// nopass) rules.
+type Become struct {
+	Method string // BecomeSudo or BecomeDoas
+	User   string // Defaults to root
+}
+
+func (b *Become) Validate() error {
+	switch b.Method {
+	case BecomeSudo, BecomeDoas:
+		return nil
+	}
+	return fmt.Errorf("Unknown become method %#v: Expected %s or %s", b.Method, BecomeSudo, BecomeDoas)
+}
+
+func (b *Become) user() string {
+	if b.User == "" {
+		return "root"
+	}
+	return b.User
+}
+
+// Args is the command prefix, ready for the program and its arguments
+func (b *Become) Args() []string {
+	if b.Method == BecomeDoas {
+		return []string{"doas", "-n", "-u", b.user()}
+	}
+	return []string{"sudo", "-n", "-u", b.user(), "--"}
+}
+
+// Wrap returns a command line that runs cmdline in a shell as the target
+// user, so redirects and "cd dir &&" happen with its privileges too.
+func (b *Become) Wrap(cmdline string) string {
+	r := ""
+	for _, a := range b.Args() {
+		r += shell.ReadableEscapeArg(a) + " "
+	}
+	return r + "sh -c " + shell.ReadableEscapeArg(cmdline)
+}
+
+func (b *Become) String() string {
+	return b.Method + " " + b.user()
+}
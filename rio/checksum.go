@@ -0,0 +1,38 @@
+package rio
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// Checksum algorithms for Host.Checksum. Sums are lowercase hex.
+const (
+	SHA256 = "sha256"
+	SHA512 = "sha512"
+)
+
+func NewHash(algo string) (hash.Hash, error) {
+	switch algo {
+	case SHA256:
+		return sha256.New(), nil
+	case SHA512:
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("Unknown checksum algorithm %#v: Expected %s or %s", algo, SHA256, SHA512)
+}
+
+// Sum checksums everything read from r
+func Sum(algo string, r io.Reader) (string, error) {
+	h, err := NewHash(algo)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,31 @@
+package dry
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"syscall"
+
+	"khan.rip/rio"
+)
+
+func (host *Host) Checksum(ctx context.Context, fpath, algo string) (string, error) {
+	host.fsmu.Lock()
+	fpath = host.resolve(fpath)
+	file := host.fs[fpath]
+	// Files we only know the metadata of (chmod, chown) still have their
+	// content on the cascade host
+	if (file == nil || (file.info != nil && !file.info.Fisdir && file.content == nil)) && host.cascade != nil {
+		host.fsmu.Unlock()
+		return host.cascade.Checksum(ctx, fpath, algo)
+	}
+	defer host.fsmu.Unlock()
+
+	if file == nil || file.info == nil {
+		return "", &os.PathError{Op: "checksum", Path: fpath, Err: syscall.ENOENT}
+	}
+	if file.info.IsDir() {
+		return "", &os.PathError{Op: "checksum", Path: fpath, Err: syscall.EISDIR}
+	}
+	return rio.Sum(algo, bytes.NewReader(file.content))
+}
@@ -1,19 +1,20 @@
 package dry
 
 import (
+	"context"
 	"path"
 	"time"
 
 	"khan.rip/rio/util"
 )
 
-func (host *Host) MkdirAll(fpath string) error {
+func (host *Host) MkdirAll(ctx context.Context, fpath string) error {
 	host.fsmu.Lock()
 	defer host.fsmu.Unlock()
 
 	file := host.fs[fpath]
 	if file == nil && host.cascade != nil {
-		f, err := host.cascade.Stat(fpath)
+		f, err := host.cascade.Stat(ctx, fpath)
 		if err != nil {
 			if util.IsErrNotFound(err) {
 				// Okay cool, we can make file here.
@@ -37,7 +38,7 @@ func (host *Host) MkdirAll(fpath string) error {
 		return nil
 	}
 
-	if err := util.MkdirAll(host, fpath); err != nil {
+	if err := util.MkdirAll(ctx, host, fpath); err != nil {
 		return err
 	}
 
@@ -2,6 +2,7 @@ package dry
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -13,10 +14,11 @@ import (
 type File struct {
 	info    *util.FileInfo // nil info means file not present (deleted)
 	content []byte         // nil content means content not cached. (zero length slice means empty file.)
+	link    string         // target, when info.Fislink
 }
 
 func (f *File) String() string {
-	return fmt.Sprintf("info %s content %#v\n", f.info, string(f.content))
+	return fmt.Sprintf("info %s content %#v link %#v\n", f.info, string(f.content), f.link)
 }
 
 type Reader struct {
@@ -30,13 +32,14 @@ func (r *Reader) Close() error {
 	return nil
 }
 
-func (host *Host) Open(fpath string) (io.ReadCloser, error) {
+func (host *Host) Open(ctx context.Context, fpath string) (io.ReadCloser, error) {
 	host.fsmu.Lock()
+	fpath = host.resolve(fpath)
 	file := host.fs[fpath]
 	if file == nil && host.cascade != nil {
 		// we don't want to hold this lock while SSH does its thing if we don't have to
 		host.fsmu.Unlock()
-		return host.cascade.Open(fpath)
+		return host.cascade.Open(ctx, fpath)
 	}
 	defer host.fsmu.Unlock()
 
@@ -55,9 +58,9 @@ func (host *Host) Open(fpath string) (io.ReadCloser, error) {
 	return reader, nil
 }
 
-func (host *Host) ReadFile(fpath string) ([]byte, error) {
+func (host *Host) ReadFile(ctx context.Context, fpath string) ([]byte, error) {
 	buf := &bytes.Buffer{}
-	fh, err := host.Open(fpath)
+	fh, err := host.Open(ctx, fpath)
 	if err != nil {
 		return nil, err
 	}
@@ -1,35 +1,88 @@
 package dry
 
 import (
+	"context"
 	"os"
+	"path"
 	"syscall"
 )
 
-func (host *Host) Stat(fpath string) (os.FileInfo, error) {
+// Links we've made ourselves are followed here. Anything else the cascade
+// follows on its own.
+const maxLinks = 40
+
+func (host *Host) Stat(ctx context.Context, fpath string) (os.FileInfo, error) {
 	host.fsmu.Lock()
-	file := host.fs[fpath]
+	file := host.fs[host.resolve(fpath)]
 	if file == nil && host.cascade != nil {
 		// we don't want to hold this lock while SSH does its thing if we don't have to
 		host.fsmu.Unlock()
-		return host.cascade.Stat(fpath)
+		return host.cascade.Stat(ctx, host.resolve(fpath))
 	}
 	defer host.fsmu.Unlock()
 
+	return host.stat(ctx, fpath)
+}
+
+// stat() should be called when fsmu is already locked.
+func (host *Host) stat(ctx context.Context, fpath string) (os.FileInfo, error) {
+	rpath := host.resolve(fpath)
+	file := host.fs[rpath]
+	if file == nil && host.cascade != nil {
+		return host.cascade.Stat(ctx, rpath)
+	}
 	if file == nil || file.info == nil {
 		return nil, &os.PathError{Op: "stat", Path: fpath, Err: syscall.ENOENT}
 	}
-
+	if file.info.Fislink {
+		return nil, &os.PathError{Op: "stat", Path: fpath, Err: syscall.ELOOP}
+	}
 	return file.info, nil
 }
 
-// stat() should be called when fsmu is already locked.
-func (host *Host) stat(fpath string) (os.FileInfo, error) {
+// resolve follows the symlinks in fs. Call it with fsmu locked.
+func (host *Host) resolve(fpath string) string {
+	for i := 0; i < maxLinks; i++ {
+		file := host.fs[fpath]
+		if file == nil || file.info == nil || !file.info.Fislink {
+			return fpath
+		}
+		target := file.link
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(fpath), target)
+		}
+		fpath = target
+	}
+	return fpath
+}
+
+func (host *Host) Lstat(ctx context.Context, fpath string) (os.FileInfo, error) {
+	host.fsmu.Lock()
 	file := host.fs[fpath]
+	host.fsmu.Unlock()
+
 	if file == nil && host.cascade != nil {
-		return host.cascade.Stat(fpath)
+		return host.cascade.Lstat(ctx, fpath)
 	}
 	if file == nil || file.info == nil {
-		return nil, &os.PathError{Op: "stat", Path: fpath, Err: syscall.ENOENT}
+		return nil, &os.PathError{Op: "lstat", Path: fpath, Err: syscall.ENOENT}
 	}
 	return file.info, nil
 }
+
+func (host *Host) Readlink(ctx context.Context, fpath string) (string, error) {
+	host.fsmu.Lock()
+	file := host.fs[fpath]
+	host.fsmu.Unlock()
+
+	if file == nil && host.cascade != nil {
+		return host.cascade.Readlink(ctx, fpath)
+	}
+	if file == nil || file.info == nil {
+		return "", &os.PathError{Op: "readlink", Path: fpath, Err: syscall.ENOENT}
+	}
+	if !file.info.Fislink {
+		return "", &os.PathError{Op: "readlink", Path: fpath, Err: syscall.EINVAL}
+	}
+	return file.link, nil
+}
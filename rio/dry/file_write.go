@@ -2,6 +2,7 @@ package dry
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -40,7 +41,7 @@ func (w *Writer) Close() error {
 	return nil
 }
 
-func (host *Host) Create(fpath string) (io.WriteCloser, error) {
+func (host *Host) Create(ctx context.Context, fpath string) (io.WriteCloser, error) {
 	if host.verbose {
 		log.Println(host, ">", fpath)
 	}
@@ -81,7 +82,7 @@ func (host *Host) Create(fpath string) (io.WriteCloser, error) {
 	return writer, nil
 }
 
-func (host *Host) Remove(fpath string) error {
+func (host *Host) Remove(ctx context.Context, fpath string) error {
 	host.fsmu.Lock()
 	defer host.fsmu.Unlock()
 
@@ -94,7 +95,7 @@ func (host *Host) Remove(fpath string) error {
 		}
 	}
 
-	if err := util.Remove(host, fpath); err != nil {
+	if err := util.Remove(ctx, host, fpath); err != nil {
 		return err
 	}
 
@@ -102,12 +103,12 @@ func (host *Host) Remove(fpath string) error {
 	return nil
 }
 
-func (host *Host) Rename(fpath, newpath string) error {
+func (host *Host) Rename(ctx context.Context, fpath, newpath string) error {
 	host.fsmu.Lock()
 	defer host.fsmu.Unlock()
 
-	sa, erra := host.stat(fpath)
-	sb, errb := host.stat(newpath)
+	sa, erra := host.stat(ctx, fpath)
+	sb, errb := host.stat(ctx, newpath)
 
 	if errb == nil && sb.IsDir() {
 		return fmt.Errorf("mv: cannot overwrite directory %#v", newpath)
@@ -125,7 +126,7 @@ func (host *Host) Rename(fpath, newpath string) error {
 	// rename followed by a read would not return the correct contents. Maybe in the future, this could
 	// be replaced by a sort of virtual symlink to the cascade filesystem's path?
 	if file == nil && host.cascade != nil {
-		buf, err := host.cascade.ReadFile(fpath)
+		buf, err := host.cascade.ReadFile(ctx, fpath)
 		if err != nil {
 			return err
 		}
@@ -145,7 +146,7 @@ func (host *Host) Rename(fpath, newpath string) error {
 		return &os.PathError{Op: "mv", Path: fpath, Err: syscall.ENOENT}
 	}
 
-	if err := util.Rename(host, fpath, newpath); err != nil {
+	if err := util.Rename(ctx, host, fpath, newpath); err != nil {
 		return err
 	}
 
@@ -157,13 +158,14 @@ func (host *Host) Rename(fpath, newpath string) error {
 	return nil
 }
 
-func (host *Host) Chmod(fpath string, mode os.FileMode) error {
+func (host *Host) Chmod(ctx context.Context, fpath string, mode os.FileMode) error {
 	host.fsmu.Lock()
 	defer host.fsmu.Unlock()
+	fpath = host.resolve(fpath)
 
 	file := host.fs[fpath]
 	if file == nil && host.cascade != nil {
-		f, err := host.cascade.Stat(fpath)
+		f, err := host.cascade.Stat(ctx, fpath)
 		if err != nil {
 			return err
 		}
@@ -182,7 +184,7 @@ func (host *Host) Chmod(fpath string, mode os.FileMode) error {
 		return &os.PathError{Op: "chmod", Path: fpath, Err: syscall.ENOENT}
 	}
 
-	if err := util.Chmod(host, fpath, mode); err != nil {
+	if err := util.Chmod(ctx, host, fpath, mode); err != nil {
 		return err
 	}
 
@@ -190,13 +192,14 @@ func (host *Host) Chmod(fpath string, mode os.FileMode) error {
 	return nil
 }
 
-func (host *Host) Chown(fpath string, uid uint32, gid uint32) error {
+func (host *Host) Chown(ctx context.Context, fpath string, uid uint32, gid uint32) error {
 	host.fsmu.Lock()
 	defer host.fsmu.Unlock()
+	fpath = host.resolve(fpath)
 
 	file := host.fs[fpath]
 	if file == nil && host.cascade != nil {
-		f, err := host.cascade.Stat(fpath)
+		f, err := host.cascade.Stat(ctx, fpath)
 		if err != nil {
 			return err
 		}
@@ -213,7 +216,80 @@ func (host *Host) Chown(fpath string, uid uint32, gid uint32) error {
 		return &os.PathError{Op: "chown", Path: fpath, Err: syscall.ENOENT}
 	}
 
-	if err := util.Chown(host, fpath, uid, gid); err != nil {
+	if err := util.Chown(ctx, host, fpath, uid, gid); err != nil {
+		return err
+	}
+
+	file.info.Fuid = uid
+	file.info.Fgid = gid
+	return nil
+}
+
+func (host *Host) Symlink(ctx context.Context, target, fpath string) error {
+	host.fsmu.Lock()
+	defer host.fsmu.Unlock()
+
+	file := host.fs[fpath]
+	exists := file != nil && file.info != nil
+	if file == nil && host.cascade != nil {
+		if _, err := host.cascade.Lstat(ctx, fpath); err == nil {
+			exists = true
+		} else if !util.IsErrNotFound(err) {
+			return err
+		}
+	}
+	if exists {
+		return &os.LinkError{Op: "symlink", Old: target, New: fpath, Err: syscall.EEXIST}
+	}
+
+	if err := util.Symlink(ctx, host, target, fpath); err != nil {
+		return err
+	}
+
+	host.fs[fpath] = &File{
+		info: &util.FileInfo{
+			Fname:    path.Base(fpath),
+			Fsize:    int64(len(target)),
+			Fmode:    util.S_iflnk | 0777,
+			Fmodtime: time.Now(),
+			Fislink:  true,
+			Fuid:     host.uid,
+			Fgid:     host.gid,
+		},
+		link: target,
+	}
+	return nil
+}
+
+func (host *Host) Lchown(ctx context.Context, fpath string, uid uint32, gid uint32) error {
+	host.fsmu.Lock()
+	defer host.fsmu.Unlock()
+
+	file := host.fs[fpath]
+	if file == nil && host.cascade != nil {
+		f, err := host.cascade.Lstat(ctx, fpath)
+		if err != nil {
+			return err
+		}
+		info, err := util.ConvertStat(f)
+		if err != nil {
+			return err
+		}
+		file = &File{
+			info: info,
+		}
+		if info.Fislink {
+			if file.link, err = host.cascade.Readlink(ctx, fpath); err != nil {
+				return err
+			}
+		}
+		host.fs[fpath] = file
+	}
+	if file == nil || file.info == nil {
+		return &os.PathError{Op: "lchown", Path: fpath, Err: syscall.ENOENT}
+	}
+
+	if err := util.Lchown(ctx, host, fpath, uid, gid); err != nil {
 		return err
 	}
 
@@ -1,6 +1,7 @@
 package dry
 
 import (
+	"context"
 	"fmt"
 	"runtime"
 	"sync"
@@ -26,6 +27,12 @@ type Host struct {
 	users     map[string]*rio.User
 	groups    map[string]*rio.Group
 	passwords map[string]*rio.Password
+
+	servicesmu sync.Mutex
+	services   map[string]*rio.Service
+
+	packagesmu sync.Mutex
+	packages   map[string]*rio.Package
 }
 
 func (host *Host) String() string {
@@ -54,12 +61,14 @@ func New(uid, gid uint32, cascade rio.Host) *Host {
 		users:     map[string]*rio.User{},
 		groups:    map[string]*rio.Group{},
 		passwords: map[string]*rio.Password{},
+		services:  map[string]*rio.Service{},
+		packages:  map[string]*rio.Package{},
 	}
 }
 
-func (host *Host) Info() (*rio.Info, error) {
+func (host *Host) Info(ctx context.Context) (*rio.Info, error) {
 	if host.cascade != nil {
-		return host.cascade.Info()
+		return host.cascade.Info(ctx)
 	}
 
 	// TODO let you customize this
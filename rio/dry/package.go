@@ -0,0 +1,48 @@
+package dry
+
+import (
+	"context"
+	"khan.rip/rio"
+	"khan.rip/rio/util"
+)
+
+func (host *Host) Package(ctx context.Context, name string) (*rio.Package, error) {
+	host.packagesmu.Lock()
+	defer host.packagesmu.Unlock()
+
+	old, ok := host.packages[name]
+	if ok {
+		return old, nil
+	}
+	if host.cascade != nil {
+		return host.cascade.Package(ctx, name)
+	}
+	return nil, nil
+}
+
+func (host *Host) InstallPackages(ctx context.Context, pkgs []*rio.Package) error {
+	host.packagesmu.Lock()
+	defer host.packagesmu.Unlock()
+
+	if err := util.InstallPackages(ctx, host, pkgs); err != nil {
+		return err
+	}
+	for _, p := range pkgs {
+		pp := *p
+		host.packages[p.Name] = &pp
+	}
+	return nil
+}
+
+func (host *Host) RemovePackages(ctx context.Context, names []string) error {
+	host.packagesmu.Lock()
+	defer host.packagesmu.Unlock()
+
+	if err := util.RemovePackages(ctx, host, names); err != nil {
+		return err
+	}
+	for _, name := range names {
+		host.packages[name] = nil // tombstone
+	}
+	return nil
+}
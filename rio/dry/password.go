@@ -1,13 +1,14 @@
 package dry
 
 import (
+	"context"
 	"fmt"
 
 	"khan.rip/rio"
 	"khan.rip/rio/util"
 )
 
-func (host *Host) Password(name string) (*rio.Password, error) {
+func (host *Host) Password(ctx context.Context, name string) (*rio.Password, error) {
 	host.usersmu.Lock()
 	defer host.usersmu.Unlock()
 
@@ -16,19 +17,19 @@ func (host *Host) Password(name string) (*rio.Password, error) {
 		return old, nil
 	}
 	if host.cascade != nil {
-		return host.cascade.Password(name)
+		return host.cascade.Password(ctx, name)
 	}
 	return nil, nil
 }
 
-func (host *Host) UpdatePassword(password *rio.Password) error {
+func (host *Host) UpdatePassword(ctx context.Context, password *rio.Password) error {
 	host.usersmu.Lock()
 	defer host.usersmu.Unlock()
 
 	old, ok := host.passwords[password.Name]
 	if !ok && host.cascade != nil {
 		var err error
-		old, err = host.cascade.Password(password.Name)
+		old, err = host.cascade.Password(ctx, password.Name)
 		if err != nil {
 			return err
 		}
@@ -36,7 +37,7 @@ func (host *Host) UpdatePassword(password *rio.Password) error {
 	if old == nil {
 		return fmt.Errorf("Cannot set password: User %#v does not exist", password.Name)
 	}
-	if err := util.UpdatePassword(host, old, password); err != nil {
+	if err := util.UpdatePassword(ctx, host, old, password); err != nil {
 		return err
 	}
 	host.passwords[password.Name] = password
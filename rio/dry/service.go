@@ -0,0 +1,91 @@
+package dry
+
+import (
+	"context"
+	"fmt"
+
+	"khan.rip/rio"
+	"khan.rip/rio/util"
+)
+
+func (host *Host) Service(ctx context.Context, name string) (*rio.Service, error) {
+	host.servicesmu.Lock()
+	defer host.servicesmu.Unlock()
+
+	old, ok := host.services[name]
+	if ok {
+		return old, nil
+	}
+	if host.cascade != nil {
+		return host.cascade.Service(ctx, name)
+	}
+	return nil, nil
+}
+
+func (host *Host) UpdateService(ctx context.Context, service *rio.Service) error {
+	host.servicesmu.Lock()
+	defer host.servicesmu.Unlock()
+
+	old, ok := host.services[service.Unit]
+	if !ok && host.cascade != nil {
+		var err error
+		old, err = host.cascade.Service(ctx, service.Unit)
+		if err != nil {
+			return err
+		}
+	}
+	if old == nil {
+		return fmt.Errorf("Service %#v does not exist", service.Unit)
+	}
+	if err := util.UpdateService(ctx, host, old, service); err != nil {
+		return err
+	}
+	host.services[service.Unit] = service
+	return nil
+}
+
+func (host *Host) RestartService(ctx context.Context, name string) error {
+	host.servicesmu.Lock()
+	defer host.servicesmu.Unlock()
+
+	old, ok := host.services[name]
+	if !ok && host.cascade != nil {
+		var err error
+		old, err = host.cascade.Service(ctx, name)
+		if err != nil {
+			return err
+		}
+	}
+	if old == nil {
+		return fmt.Errorf("Service %#v does not exist", name)
+	}
+	if err := util.RestartService(ctx, host, name); err != nil {
+		return err
+	}
+	s := *old
+	s.Active = "active"
+	s.Sub = "running"
+	host.services[name] = &s
+	return nil
+}
+
+func (host *Host) ReloadService(ctx context.Context, name string) error {
+	host.servicesmu.Lock()
+	defer host.servicesmu.Unlock()
+
+	old, ok := host.services[name]
+	if !ok && host.cascade != nil {
+		var err error
+		old, err = host.cascade.Service(ctx, name)
+		if err != nil {
+			return err
+		}
+	}
+	if old == nil {
+		return fmt.Errorf("Service %#v does not exist", name)
+	}
+	if !old.Running() {
+		return fmt.Errorf("Cannot reload service %#v: not running", name)
+	}
+	return util.ReloadService(ctx, host, name)
+}
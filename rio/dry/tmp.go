@@ -1,6 +1,7 @@
 package dry
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path"
@@ -9,8 +10,8 @@ import (
 	"khan.rip/rio/util"
 )
 
-func (host *Host) TmpFile() (string, error) {
-	tmpdir, err := host.TmpDir()
+func (host *Host) TmpFile(ctx context.Context) (string, error) {
+	tmpdir, err := host.TmpDir(ctx)
 	if err != nil {
 		return "", err
 	}
@@ -28,7 +29,7 @@ func (host *Host) TmpFile() (string, error) {
 	return fpath, nil
 }
 
-func (host *Host) TmpDir() (string, error) {
+func (host *Host) TmpDir(ctx context.Context) (string, error) {
 	host.fsmu.Lock()
 	defer host.fsmu.Unlock()
 
@@ -43,7 +44,7 @@ func (host *Host) TmpDir() (string, error) {
 		fpath = fmt.Sprintf("/tmp/tmpkhan_%d", i)
 	}
 
-	if err := util.Mkdir(host, fpath); err != nil {
+	if err := util.Mkdir(ctx, host, fpath); err != nil {
 		return "", err
 	}
 	file := &File{
@@ -63,13 +64,15 @@ func (host *Host) TmpDir() (string, error) {
 }
 
 func (host *Host) Cleanup() error {
+	ctx := context.Background()
+
 	host.fsmu.Lock()
 	defer host.fsmu.Unlock()
 
 	if host.tmpdir == "" {
 		return nil
 	}
-	if err := util.RemoveAll(host, host.tmpdir); err != nil {
+	if err := util.RemoveAll(ctx, host, host.tmpdir); err != nil {
 		return err
 	}
 	host.fs[host.tmpdir] = &File{}
@@ -1,13 +1,15 @@
 package dry
 
 import (
+	"context"
 	"fmt"
+	"path"
 
 	"khan.rip/rio"
 	"khan.rip/rio/util"
 )
 
-func (host *Host) Group(name string) (*rio.Group, error) {
+func (host *Host) Group(ctx context.Context, name string) (*rio.Group, error) {
 	host.usersmu.Lock()
 	defer host.usersmu.Unlock()
 
@@ -16,19 +18,40 @@ func (host *Host) Group(name string) (*rio.Group, error) {
 		return old, nil
 	}
 	if host.cascade != nil {
-		return host.cascade.Group(name)
+		return host.cascade.Group(ctx, name)
 	}
 	return nil, nil
 }
 
-func (host *Host) CreateGroup(group *rio.Group) error {
+func (host *Host) Groups(ctx context.Context) ([]*rio.Group, error) {
+	host.usersmu.Lock()
+	defer host.usersmu.Unlock()
+
+	// What we've changed, over what's there
+	groups := map[string]*rio.Group{}
+	if host.cascade != nil {
+		list, err := host.cascade.Groups(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range list {
+			groups[g.Name] = g
+		}
+	}
+	for name, g := range host.groups {
+		groups[name] = g
+	}
+	return util.SortedGroups(groups), nil
+}
+
+func (host *Host) CreateGroup(ctx context.Context, group *rio.Group) error {
 	host.usersmu.Lock()
 	defer host.usersmu.Unlock()
 
 	old, ok := host.groups[group.Name]
 	if !ok && host.cascade != nil {
 		var err error
-		old, err = host.cascade.Group(group.Name)
+		old, err = host.cascade.Group(ctx, group.Name)
 		if err != nil {
 			return err
 		}
@@ -37,23 +60,30 @@ func (host *Host) CreateGroup(group *rio.Group) error {
 		return fmt.Errorf("Group %#v already exists", group.Name)
 	}
 
-	gid, err := util.CreateGroup(host, group)
+	gid, err := util.CreateGroup(ctx, host, group)
 	if err != nil {
 		return err
 	}
 	group.Gid = gid // Yuck
-	host.groups[group.Name] = group
+	c := *group
+	c.Members = nil
+	host.groups[group.Name] = &c
+	for _, name := range group.Members {
+		if err := host.setMembership(ctx, name, group.Name, true); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (host *Host) UpdateGroup(group *rio.Group) error {
+func (host *Host) UpdateGroup(ctx context.Context, group *rio.Group) error {
 	host.usersmu.Lock()
 	defer host.usersmu.Unlock()
 
 	old, ok := host.groups[group.Name]
 	if !ok && host.cascade != nil {
 		var err error
-		old, err = host.cascade.Group(group.Name)
+		old, err = host.cascade.Group(ctx, group.Name)
 		if err != nil {
 			return err
 		}
@@ -61,21 +91,33 @@ func (host *Host) UpdateGroup(group *rio.Group) error {
 	if old == nil {
 		return fmt.Errorf("Group %#v does not exist", group.Name)
 	}
-	if err := util.UpdateGroup(host, old, group); err != nil {
+	if err := util.UpdateGroup(ctx, host, old, group); err != nil {
 		return err
 	}
 	host.groups[group.Name] = group
+
+	added, removed := util.DiffNames(old.Members, group.Members)
+	for _, name := range added {
+		if err := host.setMembership(ctx, name, group.Name, true); err != nil {
+			return err
+		}
+	}
+	for _, name := range removed {
+		if err := host.setMembership(ctx, name, group.Name, false); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (host *Host) DeleteGroup(name string) error {
+func (host *Host) DeleteGroup(ctx context.Context, name string) error {
 	host.usersmu.Lock()
 	defer host.usersmu.Unlock()
 
 	old, ok := host.groups[name]
 	if !ok && host.cascade != nil {
 		var err error
-		old, err = host.cascade.Group(name)
+		old, err = host.cascade.Group(ctx, name)
 		if err != nil {
 			return err
 		}
@@ -83,14 +125,14 @@ func (host *Host) DeleteGroup(name string) error {
 	if old == nil {
 		return fmt.Errorf("Group %#v does not exist", name)
 	}
-	if err := util.DeleteGroup(host, name); err != nil {
+	if err := util.DeleteGroup(ctx, host, name); err != nil {
 		return err
 	}
 	host.groups[name] = nil // tombstone
 	return nil
 }
 
-func (host *Host) User(name string) (*rio.User, error) {
+func (host *Host) User(ctx context.Context, name string) (*rio.User, error) {
 	host.usersmu.Lock()
 	defer host.usersmu.Unlock()
 
@@ -99,19 +141,40 @@ func (host *Host) User(name string) (*rio.User, error) {
 		return old, nil
 	}
 	if host.cascade != nil {
-		return host.cascade.User(name)
+		return host.cascade.User(ctx, name)
 	}
 	return nil, nil
 }
 
-func (host *Host) CreateUser(user *rio.User) error {
+func (host *Host) Users(ctx context.Context) ([]*rio.User, error) {
+	host.usersmu.Lock()
+	defer host.usersmu.Unlock()
+
+	// What we've changed, over what's there
+	users := map[string]*rio.User{}
+	if host.cascade != nil {
+		list, err := host.cascade.Users(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range list {
+			users[u.Name] = u
+		}
+	}
+	for name, u := range host.users {
+		users[name] = u
+	}
+	return util.SortedUsers(users), nil
+}
+
+func (host *Host) CreateUser(ctx context.Context, user *rio.User, createHome bool) error {
 	host.usersmu.Lock()
 	defer host.usersmu.Unlock()
 
 	old, ok := host.users[user.Name]
 	if !ok && host.cascade != nil {
 		var err error
-		old, err = host.cascade.User(user.Name)
+		old, err = host.cascade.User(ctx, user.Name)
 		if err != nil {
 			return err
 		}
@@ -120,28 +183,36 @@ func (host *Host) CreateUser(user *rio.User) error {
 		return fmt.Errorf("User %#v already exists", user.Name)
 	}
 
-	uid, err := util.CreateUser(host, user)
+	uid, err := util.CreateUser(ctx, host, user, createHome)
 	if err != nil {
 		return err
 	}
 	user.Uid = uid // Yuck
 	host.users[user.Name] = user
-	host.passwords[user.Name] = &rio.Password{
-		Name:  user.Name,
-		Crypt: "!", // maybe be fancy later and make "*" if cascade upstream is openbsd
+	if createHome {
+		if err := host.mkhome(ctx, user); err != nil {
+			return err
+		}
+	}
+	// maybe be fancy later and make "*" if cascade upstream is openbsd
+	host.passwords[user.Name] = rio.NoAging(user.Name, "!")
+	for _, group := range user.Groups {
+		if err := host.setMembership(ctx, user.Name, group, true); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (host *Host) UpdateUser(user *rio.User) error {
+func (host *Host) UpdateUser(ctx context.Context, user *rio.User, moveHome bool) error {
 	host.usersmu.Lock()
 	defer host.usersmu.Unlock()
 
 	old, ok := host.users[user.Name]
 	if !ok && host.cascade != nil {
 		var err error
-		old, err = host.cascade.User(user.Name)
+		old, err = host.cascade.User(ctx, user.Name)
 		if err != nil {
 			return err
 		}
@@ -149,21 +220,36 @@ func (host *Host) UpdateUser(user *rio.User) error {
 	if old == nil {
 		return fmt.Errorf("User %#v does not exist", user.Name)
 	}
-	if err := util.UpdateUser(host, old, user); err != nil {
+	if err := util.UpdateUser(ctx, host, old, user, moveHome); err != nil {
 		return err
 	}
 	host.users[user.Name] = user
+	if moveHome && old.Home != user.Home {
+		host.mvhome(ctx, old.Home, user.Home)
+	}
+
+	added, removed := util.DiffNames(old.Groups, user.Groups)
+	for _, name := range added {
+		if err := host.setMembership(ctx, user.Name, name, true); err != nil {
+			return err
+		}
+	}
+	for _, name := range removed {
+		if err := host.setMembership(ctx, user.Name, name, false); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (host *Host) DeleteUser(name string) error {
+func (host *Host) DeleteUser(ctx context.Context, name string, removeHome bool) error {
 	host.usersmu.Lock()
 	defer host.usersmu.Unlock()
 
 	old, ok := host.users[name]
 	if !ok && host.cascade != nil {
 		var err error
-		old, err = host.cascade.User(name)
+		old, err = host.cascade.User(ctx, name)
 		if err != nil {
 			return err
 		}
@@ -171,9 +257,115 @@ func (host *Host) DeleteUser(name string) error {
 	if old == nil {
 		return fmt.Errorf("User %#v does not exist", name)
 	}
-	if err := util.DeleteUser(host, name); err != nil {
+	if err := util.DeleteUser(ctx, host, name, removeHome); err != nil {
 		return err
 	}
 	host.users[name] = nil // tombstone
+	if removeHome && old.Home != "" {
+		host.fsmu.Lock()
+		host.fs[old.Home] = &File{}
+		host.fsmu.Unlock()
+	}
+	for _, group := range old.Groups {
+		if err := host.setMembership(ctx, name, group, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mkhome models useradd -m: a home directory owned by the user, if there
+// wasn't one. Only the directory itself, not what's copied from /etc/skel.
+// Call it with usersmu locked.
+func (host *Host) mkhome(ctx context.Context, user *rio.User) error {
+	if _, err := host.Stat(ctx, user.Home); err == nil || !util.IsErrNotFound(err) {
+		return err
+	}
+
+	var gid uint32
+	g, ok := host.groups[user.Group]
+	if !ok && host.cascade != nil {
+		var err error
+		if g, err = host.cascade.Group(ctx, user.Group); err != nil {
+			return err
+		}
+	}
+	if g != nil {
+		gid = g.Gid
+	}
+
+	if err := host.MkdirAll(ctx, user.Home); err != nil {
+		return err
+	}
+	host.fsmu.Lock()
+	defer host.fsmu.Unlock()
+	if file := host.fs[user.Home]; file != nil && file.info != nil {
+		file.info.Fuid, file.info.Fgid = user.Uid, gid
+	}
 	return nil
 }
+
+// mvhome models usermod -m. The directory moves, what's in it stays where
+// the cascade has it.
+func (host *Host) mvhome(ctx context.Context, oldhome, newhome string) {
+	host.fsmu.Lock()
+	defer host.fsmu.Unlock()
+
+	fi, err := host.stat(ctx, oldhome)
+	if err != nil {
+		return
+	}
+	ufi, err := util.ConvertStat(fi)
+	if err != nil {
+		return
+	}
+	info := *ufi
+	info.Fname = path.Base(newhome)
+	host.fs[oldhome] = &File{}
+	host.fs[newhome] = &File{info: &info}
+}
+
+// setMembership puts user in group or takes them out, on both sides of the
+// model: the user's Groups and the group's Members. Call it with usersmu
+// locked.
+func (host *Host) setMembership(ctx context.Context, user, group string, member bool) error {
+	u, ok := host.users[user]
+	if !ok && host.cascade != nil {
+		var err error
+		if u, err = host.cascade.User(ctx, user); err != nil {
+			return err
+		}
+	}
+	if u != nil {
+		c := *u
+		c.Groups = setName(u.Groups, group, member)
+		host.users[user] = &c
+	}
+
+	g, ok := host.groups[group]
+	if !ok && host.cascade != nil {
+		var err error
+		if g, err = host.cascade.Group(ctx, group); err != nil {
+			return err
+		}
+	}
+	if g != nil {
+		c := *g
+		c.Members = setName(g.Members, user, member)
+		host.groups[group] = &c
+	}
+	return nil
+}
+
+func setName(names []string, name string, present bool) []string {
+	var r []string
+	for _, n := range names {
+		if n != name {
+			r = append(r, n)
+		}
+	}
+	if present {
+		r = append(r, name)
+	}
+	return r
+}
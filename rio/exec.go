@@ -63,3 +63,13 @@ func ReadOnlyCommand(ctx context.Context, path string, args ...string) *Cmd {
 		ReadOnly: true,
 	}
 }
+
+// ShellScript is what Shell mode hands to bash -c: cmdline after sourcing
+// /etc/profile like a login shell, with env exported on top.
+func ShellScript(env [][2]string, cmdline string) string {
+	script := "source /etc/profile; "
+	for _, e := range env {
+		script += "export " + shell.ReadableEscapeArg(e[0]) + "=" + shell.ReadableEscapeArg(e[1]) + "; "
+	}
+	return script + cmdline
+}
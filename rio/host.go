@@ -1,6 +1,7 @@
 package rio
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -9,36 +10,52 @@ import (
 type Host interface {
 	String() string
 	SetVerbose()
-	Info() (*Info, error)
-
-	TmpFile() (string, error)
-	TmpDir() (string, error)
-	Cleanup() error
-
-	Exec(cmd *Cmd) error
-
-	Stat(string) (os.FileInfo, error)
-	Open(string) (io.ReadCloser, error)
-	ReadFile(string) ([]byte, error)
-	Create(string) (io.WriteCloser, error)
-	Remove(string) error // I'd rather call this Delete. But in this case, follow "os" package style.
-	Chmod(string, os.FileMode) error
-	Chown(string, uint32, uint32) error
-	Rename(string, string) error
-	MkdirAll(string) error
-
-	User(string) (*User, error)
-	CreateUser(*User) error
-	UpdateUser(*User) error
-	DeleteUser(string) error
-
-	Group(string) (*Group, error)
-	CreateGroup(*Group) error
-	UpdateGroup(*Group) error
-	DeleteGroup(string) error
-
-	Password(string) (*Password, error)
-	UpdatePassword(*Password) error
+	Info(context.Context) (*Info, error)
+
+	TmpFile(context.Context) (string, error)
+	TmpDir(context.Context) (string, error)
+	Cleanup() error // Not cancelable: it has to run even after the run was interrupted.
+
+	Exec(cmd *Cmd) error // Cancellation comes from cmd.Context
+
+	Stat(context.Context, string) (os.FileInfo, error)  // follows symlinks
+	Lstat(context.Context, string) (os.FileInfo, error) // doesn't
+	Readlink(context.Context, string) (string, error)
+	Symlink(context.Context, string, string) error // target, then the path of the link, like os.Symlink
+	Open(context.Context, string) (io.ReadCloser, error)
+	ReadFile(context.Context, string) ([]byte, error)
+	Checksum(context.Context, string, string) (string, error) // path, then rio.SHA256 or rio.SHA512
+	Create(context.Context, string) (io.WriteCloser, error)
+	Remove(context.Context, string) error // I'd rather call this Delete. But in this case, follow "os" package style.
+	Chmod(context.Context, string, os.FileMode) error
+	Chown(context.Context, string, uint32, uint32) error
+	Lchown(context.Context, string, uint32, uint32) error
+	Rename(context.Context, string, string) error
+	MkdirAll(context.Context, string) error
+
+	User(context.Context, string) (*User, error)
+	Users(context.Context) ([]*User, error)         // sorted by name
+	CreateUser(context.Context, *User, bool) error  // true makes the home directory, from /etc/skel
+	UpdateUser(context.Context, *User, bool) error  // true moves the home directory along with Home
+	DeleteUser(context.Context, string, bool) error // true removes the home directory
+
+	Group(context.Context, string) (*Group, error)
+	Groups(context.Context) ([]*Group, error) // sorted by name
+	CreateGroup(context.Context, *Group) error
+	UpdateGroup(context.Context, *Group) error
+	DeleteGroup(context.Context, string) error
+
+	Password(context.Context, string) (*Password, error)
+	UpdatePassword(context.Context, *Password) error
+
+	Service(context.Context, string) (*Service, error)
+	UpdateService(context.Context, *Service) error
+	RestartService(context.Context, string) error
+	ReloadService(context.Context, string) error
+
+	Package(context.Context, string) (*Package, error)
+	InstallPackages(context.Context, []*Package) error
+	RemovePackages(context.Context, []string) error
 }
 
 type Info struct {
@@ -47,6 +64,14 @@ type Info struct {
 	Kernel   string
 	OS       string
 	Arch     string
+
+	// Linux distribution ID and ID_LIKE from /etc/os-release
+	Distro     string
+	DistroLike []string
+
+	// Effective user that commands run as, after sudo or doas
+	User string
+	Uid  uint32
 }
 
 func (info *Info) String() string {
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
@@ -30,20 +31,23 @@ func run() error {
 		return fmt.Errorf("Failed to open SSH_AUTH_SOCK: %w", err)
 	}
 	agentClient := agent.NewClient(conn)
+	hostkeycallback, err := remote.HostKeyCallback(remote.HostKeyStrict, "")
+	if err != nil {
+		return err
+	}
 	sshconfig := &ssh.ClientConfig{
 		Auth: []ssh.AuthMethod{
 			ssh.PublicKeysCallback(agentClient.Signers),
 		},
-		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-			// TODO
-			return nil
-		},
-		BannerCallback: ssh.BannerDisplayStderr(),
+		HostKeyCallback: hostkeycallback,
+		BannerCallback:  ssh.BannerDisplayStderr(),
 	}
 
 	pool := sshpool.New(sshconfig, &sshpool.PoolConfig{Debug: true})
 	defer pool.Close()
 
+	ctx := context.Background()
+
 	var hosts []rio.Host
 
 	_ = local.Host{}
@@ -58,41 +62,41 @@ func run() error {
 
 	for _, host := range hosts {
 		fmt.Println("doing host", host)
-		info, err := host.Info()
+		info, err := host.Info(ctx)
 		if err != nil {
 			return err
 		}
 		fmt.Println(info)
-		if err := wr(host, "/tmp/file0"); err != nil {
+		if err := wr(ctx, host, "/tmp/file0"); err != nil {
 			return err
 		}
-		if err := wr(host, "/tmp/file1"); err != nil {
+		if err := wr(ctx, host, "/tmp/file1"); err != nil {
 			return err
 		}
-		if err := host.Remove("/tmp/file0"); err != nil {
+		if err := host.Remove(ctx, "/tmp/file0"); err != nil {
 			return err
 		}
-		if err := rd(host, "/tmp/file0"); err == nil {
+		if err := rd(ctx, host, "/tmp/file0"); err == nil {
 			return fmt.Errorf("this file is supposed to be gone")
 		}
-		if err := rd(host, "/tmp/file2"); err != nil {
+		if err := rd(ctx, host, "/tmp/file2"); err != nil {
 			return err
 		}
-		if err := host.Remove("/tmp/file2"); err != nil {
+		if err := host.Remove(ctx, "/tmp/file2"); err != nil {
 			return err
 		}
-		if err := rd(host, "/tmp/file2"); err == nil {
+		if err := rd(ctx, host, "/tmp/file2"); err == nil {
 			return fmt.Errorf("this file is supposed to be gone")
 		}
 	}
 	return nil
 }
 
-func rd(host rio.Host, fpath string) error {
+func rd(ctx context.Context, host rio.Host, fpath string) error {
 	content := "hi " + fpath + "\n"
 
-	buf, err := host.ReadFile(fpath)
-	//fmt.Printf("host.ReadFile(%#v) %v, %v\n", fpath, buf, err)
+	buf, err := host.ReadFile(ctx, fpath)
+	//fmt.Printf("host.ReadFile(ctx, %#v) %v, %v\n", fpath, buf, err)
 	if err != nil {
 		return err
 	}
@@ -104,10 +108,10 @@ func rd(host rio.Host, fpath string) error {
 	return nil
 }
 
-func wr(host rio.Host, fpath string) error {
+func wr(ctx context.Context, host rio.Host, fpath string) error {
 	content := "hi " + fpath + "\n"
 
-	fh, err := host.Create(fpath)
+	fh, err := host.Create(ctx, fpath)
 	if err != nil {
 		return err
 	}
@@ -121,7 +125,7 @@ func wr(host rio.Host, fpath string) error {
 	}
 
 	// now read back
-	buf, err := host.ReadFile(fpath)
+	buf, err := host.ReadFile(ctx, fpath)
 	if err != nil {
 		return err
 	}
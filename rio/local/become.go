@@ -0,0 +1,155 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+
+	"khan.rip/rio"
+	"khan.rip/rio/util"
+
+	"github.com/keegancsmith/shell"
+)
+
+// SetBecome makes all commands and file access go through sudo or doas.
+// Call it before using the host.
+func (host *Host) SetBecome(b *rio.Become) {
+	host.become = b
+}
+
+// becomeCommand is a shell command line run through become with the
+// process' stdin or stdout streamed.
+func (host *Host) becomeCommand(ctx context.Context, cmdline string) *exec.Cmd {
+	args := append(host.become.Args(), "sh", "-c", cmdline)
+	return exec.CommandContext(ctx, args[0], args[1:]...)
+}
+
+// procReader streams a command's stdout. Like remote.Reader, the command's
+// error (e.g. a missing file) comes back from Read as well as Close.
+type procReader struct {
+	reader *io.PipeReader
+
+	closemu  sync.Mutex
+	closed   bool
+	closeerr error
+	procerr  chan error
+}
+
+func (r *procReader) Read(p []byte) (int, error) {
+	return r.reader.Read(p)
+}
+
+func (r *procReader) Close() error {
+	r.closemu.Lock()
+	defer r.closemu.Unlock()
+
+	if r.closed {
+		return r.closeerr
+	}
+	r.closed = true
+	r.reader.Close()
+	r.closeerr = <-r.procerr
+	return r.closeerr
+}
+
+type procWriter struct {
+	io.WriteCloser
+	cmd    *exec.Cmd
+	path   string
+	errbuf *bytes.Buffer
+}
+
+func (w *procWriter) Close() error {
+	cerr := w.WriteCloser.Close()
+	if err := w.cmd.Wait(); err != nil {
+		return becomeFileError("create", w.path, w.errbuf, err)
+	}
+	return cerr
+}
+
+// becomeFileError emulates the os package errors for missing files
+func becomeFileError(op, path string, errbuf *bytes.Buffer, err error) error {
+	e := strings.TrimSpace(errbuf.String())
+	if strings.HasSuffix(e, "No such file or directory") {
+		return &os.PathError{
+			Op:   op,
+			Path: path,
+			Err:  syscall.ENOENT,
+		}
+	}
+	return fmt.Errorf("%s %s: %w: %s", op, path, err, e)
+}
+
+func (host *Host) becomeOpen(ctx context.Context, fpath string) (io.ReadCloser, error) {
+	errbuf := &bytes.Buffer{}
+	r, w := io.Pipe()
+	c := host.becomeCommand(ctx, "cat "+shell.ReadableEscapeArg(fpath))
+	c.Stdout = w
+	c.Stderr = errbuf
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+
+	reader := &procReader{
+		reader:  r,
+		procerr: make(chan error, 1),
+	}
+	go func() {
+		err := c.Wait()
+		if err != nil {
+			err = becomeFileError("open", fpath, errbuf, err)
+		}
+		// This will let blocked reads finish
+		w.CloseWithError(err)
+		reader.procerr <- err
+	}()
+	return reader, nil
+}
+
+func (host *Host) becomeCreate(ctx context.Context, fpath string) (io.WriteCloser, error) {
+	errbuf := &bytes.Buffer{}
+	c := host.becomeCommand(ctx, "cat > "+shell.ReadableEscapeArg(fpath))
+	c.Stderr = errbuf
+	stdin, err := c.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+	return &procWriter{WriteCloser: stdin, cmd: c, path: fpath, errbuf: errbuf}, nil
+}
+
+func (host *Host) becomeStat(ctx context.Context, fpath string, follow bool) (os.FileInfo, error) {
+	info, err := host.Info(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statcmd := util.StatCommand(info.OS, follow)
+
+	outbuf := &bytes.Buffer{}
+	errbuf := &bytes.Buffer{}
+	c := host.becomeCommand(ctx, statcmd+" "+shell.ReadableEscapeArg(fpath))
+	c.Stdout = outbuf
+	c.Stderr = errbuf
+	err = c.Run()
+
+	return util.ParseStat(info.OS, fpath, strings.TrimSpace(outbuf.String()), strings.TrimSpace(errbuf.String()), err)
+}
+
+func (host *Host) becomeMktemp(ctx context.Context, args ...string) (string, error) {
+	buf := &bytes.Buffer{}
+	cmd := rio.Command(ctx, "mktemp", args...)
+	cmd.Stdout = buf
+	if err := host.Exec(cmd); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
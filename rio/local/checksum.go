@@ -0,0 +1,21 @@
+package local
+
+import (
+	"context"
+	"os"
+
+	"khan.rip/rio"
+	"khan.rip/rio/util"
+)
+
+func (host *Host) Checksum(ctx context.Context, fpath, algo string) (string, error) {
+	if host.become != nil {
+		return util.Checksum(ctx, host, fpath, algo)
+	}
+	fh, err := os.Open(fpath)
+	if err != nil {
+		return "", err
+	}
+	defer fh.Close()
+	return rio.Sum(algo, fh)
+}
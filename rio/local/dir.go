@@ -1,9 +1,15 @@
 package local
 
 import (
+	"context"
 	"os"
+
+	"khan.rip/rio/util"
 )
 
-func (host *Host) MkdirAll(fpath string) error {
+func (host *Host) MkdirAll(ctx context.Context, fpath string) error {
+	if host.become != nil {
+		return util.MkdirAll(ctx, host, fpath)
+	}
 	return os.MkdirAll(fpath, 0700)
 }
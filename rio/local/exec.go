@@ -8,6 +8,8 @@ import (
 	"strings"
 
 	"khan.rip/rio"
+
+	"github.com/keegancsmith/shell"
 )
 
 func (host *Host) Exec(cmd *rio.Cmd) error {
@@ -22,7 +24,29 @@ func (host *Host) Exec(cmd *rio.Cmd) error {
 		stderr = errbuf
 	}
 
-	c := exec.CommandContext(cmd.Context, cmd.Path, cmd.Args...)
+	var args []string
+	if cmd.Shell {
+		cmdline := cmd.Path
+		for _, a := range cmd.Args {
+			cmdline += " " + shell.ReadableEscapeArg(a)
+		}
+		args = []string{"bash", "-c", rio.ShellScript(cmd.Env, cmdline)}
+	} else {
+		args = append([]string{cmd.Path}, cmd.Args...)
+	}
+	if host.become != nil {
+		// sudo and doas scrub the environment, so set it on the other side
+		if len(cmd.Env) > 0 {
+			env := []string{"env"}
+			for _, e := range cmd.Env {
+				env = append(env, e[0]+"="+e[1])
+			}
+			args = append(env, args...)
+		}
+		args = append(host.become.Args(), args...)
+	}
+	c := exec.CommandContext(cmd.Context, args[0], args[1:]...)
+	c.Dir = cmd.Dir
 	c.Stdin = cmd.Stdin
 	c.Stdout = cmd.Stdout
 	c.Stderr = stderr
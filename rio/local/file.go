@@ -1,55 +1,124 @@
 package local
 
 import (
+	"context"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+
+	"khan.rip/rio/util"
 )
 
-func (host *Host) Create(fpath string) (io.WriteCloser, error) {
+func (host *Host) Create(ctx context.Context, fpath string) (io.WriteCloser, error) {
 	if host.verbose {
 		log.Println(host, ">", fpath)
 	}
+	if host.become != nil {
+		return host.becomeCreate(ctx, fpath)
+	}
 	return os.Create(fpath)
 }
 
-func (host *Host) Remove(fpath string) error {
+func (host *Host) Remove(ctx context.Context, fpath string) error {
 	if host.verbose {
 		log.Println(host, "! rm", fpath)
 	}
+	if host.become != nil {
+		return util.Remove(ctx, host, fpath)
+	}
 	return os.Remove(fpath)
 }
 
-func (host *Host) Rename(oldpath, newpath string) error {
+func (host *Host) Rename(ctx context.Context, oldpath, newpath string) error {
 	if host.verbose {
 		log.Println(host, "! mv", oldpath, newpath)
 	}
+	if host.become != nil {
+		return util.Rename(ctx, host, oldpath, newpath)
+	}
 	return os.Rename(oldpath, newpath)
 }
 
-func (host *Host) Open(fpath string) (io.ReadCloser, error) {
+func (host *Host) Open(ctx context.Context, fpath string) (io.ReadCloser, error) {
+	if host.become != nil {
+		return host.becomeOpen(ctx, fpath)
+	}
 	return os.Open(fpath)
 }
 
-func (host *Host) ReadFile(fpath string) ([]byte, error) {
+func (host *Host) ReadFile(ctx context.Context, fpath string) ([]byte, error) {
+	if host.become != nil {
+		fh, err := host.becomeOpen(ctx, fpath)
+		if err != nil {
+			return nil, err
+		}
+		buf, err := ioutil.ReadAll(fh)
+		if cerr := fh.Close(); err == nil {
+			err = cerr
+		}
+		return buf, err
+	}
 	return ioutil.ReadFile(fpath)
 }
 
-func (host *Host) Stat(fpath string) (os.FileInfo, error) {
+func (host *Host) Stat(ctx context.Context, fpath string) (os.FileInfo, error) {
+	if host.become != nil {
+		return host.becomeStat(ctx, fpath, true)
+	}
 	return os.Stat(fpath)
 }
 
-func (host *Host) Chmod(fpath string, mode os.FileMode) error {
+func (host *Host) Lstat(ctx context.Context, fpath string) (os.FileInfo, error) {
+	if host.become != nil {
+		return host.becomeStat(ctx, fpath, false)
+	}
+	return os.Lstat(fpath)
+}
+
+func (host *Host) Readlink(ctx context.Context, fpath string) (string, error) {
+	if host.become != nil {
+		return util.Readlink(ctx, host, fpath)
+	}
+	return os.Readlink(fpath)
+}
+
+func (host *Host) Symlink(ctx context.Context, target, fpath string) error {
+	if host.verbose {
+		log.Println(host, "! ln -s", target, fpath)
+	}
+	if host.become != nil {
+		return util.Symlink(ctx, host, target, fpath)
+	}
+	return os.Symlink(target, fpath)
+}
+
+func (host *Host) Chmod(ctx context.Context, fpath string, mode os.FileMode) error {
 	if host.verbose {
 		log.Printf("%s ! chmod %o %s\n", host, mode, fpath)
 	}
+	if host.become != nil {
+		return util.Chmod(ctx, host, fpath, mode)
+	}
 	return os.Chmod(fpath, mode)
 }
 
-func (host *Host) Chown(fpath string, uid uint32, gid uint32) error {
+func (host *Host) Chown(ctx context.Context, fpath string, uid uint32, gid uint32) error {
 	if host.verbose {
 		log.Printf("%s ! chown %d:%d %s\n", host, uid, gid, fpath)
 	}
+	if host.become != nil {
+		return util.Chown(ctx, host, fpath, uid, gid)
+	}
 	return os.Chown(fpath, int(uid), int(gid))
 }
+
+func (host *Host) Lchown(ctx context.Context, fpath string, uid uint32, gid uint32) error {
+	if host.verbose {
+		log.Printf("%s ! chown -h %d:%d %s\n", host, uid, gid, fpath)
+	}
+	if host.become != nil {
+		return util.Lchown(ctx, host, fpath, uid, gid)
+	}
+	return os.Lchown(fpath, int(uid), int(gid))
+}
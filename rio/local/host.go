@@ -9,17 +9,31 @@ import (
 type Host struct {
 	verbose bool
 
+	become *rio.Become
+
 	// cache
+	infomu sync.Mutex
+	info   *rio.Info
+
 	usersmu   sync.Mutex
 	users     map[string]*rio.User
 	groups    map[string]*rio.Group
 	passwords map[string]*rio.Password
 
+	servicesmu sync.Mutex
+	services   map[string]*rio.Service
+
+	packagesmu sync.Mutex
+	packages   map[string]*rio.Package
+
 	tmpdirmu sync.Mutex
 	tmpdir   string
 }
 
 func (host *Host) String() string {
+	if host.become != nil {
+		return "local (" + host.become.String() + ")"
+	}
 	return "local"
 }
 
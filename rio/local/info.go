@@ -1,21 +1,79 @@
 package local
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"os"
+	"os/user"
 	"runtime"
+	"strconv"
+	"strings"
 
 	"khan.rip/rio"
+	"khan.rip/rio/util"
 )
 
-func (host *Host) Info() (*rio.Info, error) {
+func (host *Host) Info(ctx context.Context) (*rio.Info, error) {
+	host.infomu.Lock()
+	defer host.infomu.Unlock()
+
+	if host.info != nil {
+		return host.info, nil
+	}
+
 	hostname, err := os.Hostname()
 	if err != nil {
 		return nil, err
 	}
 
-	return &rio.Info{
+	info := &rio.Info{
 		Hostname: hostname,
 		OS:       runtime.GOOS,
 		Arch:     runtime.GOARCH,
-	}, nil
+	}
+
+	if info.OS == "linux" {
+		// Not fatal: some minimal systems don't have this file.
+		if fh, err := os.Open("/etc/os-release"); err == nil {
+			defer fh.Close()
+			if info.Distro, info.DistroLike, err = util.ParseOSRelease(fh); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if host.become != nil {
+		// Also checks early that sudo or doas works without a password
+		buf := &bytes.Buffer{}
+		cmd := rio.ReadOnlyCommand(ctx, "id", "-u")
+		cmd.Stdout = buf
+		if err := host.Exec(cmd); err != nil {
+			return nil, err
+		}
+		uid, err := strconv.ParseUint(strings.TrimSpace(buf.String()), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse id -u: %w", err)
+		}
+		info.Uid = uint32(uid)
+
+		buf.Reset()
+		cmd = rio.ReadOnlyCommand(ctx, "id", "-un")
+		cmd.Stdout = buf
+		if err := host.Exec(cmd); err != nil {
+			return nil, err
+		}
+		info.User = strings.TrimSpace(buf.String())
+	} else {
+		u, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		info.Uid = uint32(os.Geteuid())
+		info.User = u.Username
+	}
+
+	host.info = info
+
+	return info, nil
 }
@@ -0,0 +1,47 @@
+package local
+
+import (
+	"context"
+	"khan.rip/rio"
+	"khan.rip/rio/util"
+)
+
+func (host *Host) Package(ctx context.Context, name string) (*rio.Package, error) {
+	host.packagesmu.Lock()
+	defer host.packagesmu.Unlock()
+
+	if host.packages == nil {
+		var err error
+		host.packages, err = util.LoadPackages(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return host.packages[name], nil
+}
+
+func (host *Host) InstallPackages(ctx context.Context, pkgs []*rio.Package) error {
+	host.packagesmu.Lock()
+	defer host.packagesmu.Unlock()
+
+	if err := util.InstallPackages(ctx, host, pkgs); err != nil {
+		return err
+	}
+
+	// The package manager picks versions and dependencies. Reload on next lookup.
+	host.packages = nil
+	return nil
+}
+
+func (host *Host) RemovePackages(ctx context.Context, names []string) error {
+	host.packagesmu.Lock()
+	defer host.packagesmu.Unlock()
+
+	if err := util.RemovePackages(ctx, host, names); err != nil {
+		return err
+	}
+
+	host.packages = nil
+	return nil
+}
@@ -1,13 +1,16 @@
 package local
 
 import (
+	"context"
 	"io/ioutil"
 	"log"
 	"os"
+
+	"khan.rip/rio/util"
 )
 
-func (host *Host) TmpFile() (string, error) {
-	tmpdir, err := host.TmpDir()
+func (host *Host) TmpFile(ctx context.Context) (string, error) {
+	tmpdir, err := host.TmpDir(ctx)
 	if err != nil {
 		return "", err
 	}
@@ -16,6 +19,10 @@ func (host *Host) TmpFile() (string, error) {
 		log.Println(host, "! mktemp -p", tmpdir, "XXXXXXXX")
 	}
 
+	if host.become != nil {
+		return host.becomeMktemp(ctx, "-p", tmpdir, "XXXXXXXX")
+	}
+
 	f, err := ioutil.TempFile(tmpdir, "")
 	if err != nil {
 		return "", err
@@ -25,7 +32,7 @@ func (host *Host) TmpFile() (string, error) {
 	return f.Name(), nil
 }
 
-func (host *Host) TmpDir() (string, error) {
+func (host *Host) TmpDir(ctx context.Context) (string, error) {
 	host.tmpdirmu.Lock()
 	defer host.tmpdirmu.Unlock()
 
@@ -37,6 +44,15 @@ func (host *Host) TmpDir() (string, error) {
 		log.Println(host, "! mktemp -d /tmp/tmpkhan_XXXXXXXX")
 	}
 
+	if host.become != nil {
+		fpath, err := host.becomeMktemp(ctx, "-d", "/tmp/tmpkhan_XXXXXXXX")
+		if err != nil {
+			return "", err
+		}
+		host.tmpdir = fpath
+		return fpath, nil
+	}
+
 	fpath, err := ioutil.TempDir("", "tmpkhan_")
 	if err != nil {
 		return "", err
@@ -55,6 +71,9 @@ func (host *Host) Cleanup() error {
 	if host.verbose {
 		log.Println(host, "! rm -rf", host.tmpdir)
 	}
+	if host.become != nil {
+		return util.RemoveAll(context.Background(), host, host.tmpdir)
+	}
 	if err := os.RemoveAll(host.tmpdir); err != nil {
 		return err
 	}
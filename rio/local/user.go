@@ -1,58 +1,94 @@
 package local
 
 import (
+	"context"
 	"khan.rip/rio"
 	"khan.rip/rio/util"
 )
 
-func (host *Host) Group(name string) (*rio.Group, error) {
+// loadUserGroups reads the users and groups, unless we have them already.
+// Call it with usersmu locked.
+func (host *Host) loadUserGroups(ctx context.Context) error {
+	if host.users != nil && host.groups != nil {
+		return nil
+	}
+	var err error
+	host.users, host.groups, err = util.LoadUserGroups(ctx, host)
+	return err
+}
+
+func (host *Host) Group(ctx context.Context, name string) (*rio.Group, error) {
 	host.usersmu.Lock()
 	defer host.usersmu.Unlock()
 
-	if host.groups == nil {
-		var err error
-		host.users, host.groups, err = util.LoadUserGroups(host)
-		if err != nil {
-			return nil, err
-		}
+	if err := host.loadUserGroups(ctx); err != nil {
+		return nil, err
 	}
 
 	return host.groups[name], nil
 }
 
-func (host *Host) CreateGroup(group *rio.Group) error {
+func (host *Host) Groups(ctx context.Context) ([]*rio.Group, error) {
+	host.usersmu.Lock()
+	defer host.usersmu.Unlock()
+
+	if err := host.loadUserGroups(ctx); err != nil {
+		return nil, err
+	}
+
+	return util.SortedGroups(host.groups), nil
+}
+
+func (host *Host) CreateGroup(ctx context.Context, group *rio.Group) error {
 	host.usersmu.Lock()
 	defer host.usersmu.Unlock()
 
-	gid, err := util.CreateGroup(host, group)
+	gid, err := util.CreateGroup(ctx, host, group)
 	if err != nil {
 		return err
 	}
 
 	group.Gid = gid // Yuck
-	host.groups[group.Name] = group
+	if len(group.Members) > 0 {
+		// Its members' groups changed too: reload both
+		host.users, host.groups = nil, nil
+		return nil
+	}
+	if host.groups != nil {
+		c := *group
+		host.groups[group.Name] = &c
+	}
 	return nil
 }
 
-func (host *Host) UpdateGroup(group *rio.Group) error {
+func (host *Host) UpdateGroup(ctx context.Context, group *rio.Group) error {
 	host.usersmu.Lock()
 	defer host.usersmu.Unlock()
 
+	if err := host.loadUserGroups(ctx); err != nil {
+		return err
+	}
+
 	old := host.groups[group.Name]
 
-	if err := util.UpdateGroup(host, old, group); err != nil {
+	if err := util.UpdateGroup(ctx, host, old, group); err != nil {
 		return err
 	}
 
+	if !util.SameNames(old.Members, group.Members) {
+		// Users' groups changed too: reload both
+		host.users, host.groups = nil, nil
+		return nil
+	}
 	host.groups[group.Name] = group
 	return nil
 }
 
-func (host *Host) DeleteGroup(name string) error {
+func (host *Host) DeleteGroup(ctx context.Context, name string) error {
 	host.usersmu.Lock()
 	defer host.usersmu.Unlock()
 
-	if err := util.DeleteGroup(host, name); err != nil {
+	if err := util.DeleteGroup(ctx, host, name); err != nil {
 		return err
 	}
 
@@ -60,56 +96,82 @@ func (host *Host) DeleteGroup(name string) error {
 	return nil
 }
 
-func (host *Host) User(name string) (*rio.User, error) {
+func (host *Host) User(ctx context.Context, name string) (*rio.User, error) {
 	host.usersmu.Lock()
 	defer host.usersmu.Unlock()
 
-	if host.users == nil {
-		var err error
-		host.users, host.groups, err = util.LoadUserGroups(host)
-		if err != nil {
-			return nil, err
-		}
+	if err := host.loadUserGroups(ctx); err != nil {
+		return nil, err
 	}
 
 	return host.users[name], nil
 }
 
-func (host *Host) CreateUser(user *rio.User) error {
+func (host *Host) Users(ctx context.Context) ([]*rio.User, error) {
+	host.usersmu.Lock()
+	defer host.usersmu.Unlock()
+
+	if err := host.loadUserGroups(ctx); err != nil {
+		return nil, err
+	}
+
+	return util.SortedUsers(host.users), nil
+}
+
+func (host *Host) CreateUser(ctx context.Context, user *rio.User, createHome bool) error {
 	host.usersmu.Lock()
 	defer host.usersmu.Unlock()
 
-	uid, err := util.CreateUser(host, user)
+	uid, err := util.CreateUser(ctx, host, user, createHome)
 	if err != nil {
 		return err
 	}
 	user.Uid = uid // Yuck
-	host.users[user.Name] = user
+	if host.users != nil {
+		host.users[user.Name] = user
+	}
+	if len(user.Groups) > 0 {
+		// Group members changed too: reload both
+		host.users, host.groups = nil, nil
+	}
+
+	// useradd filled in the shadow entry (aging from login.defs): reload it
+	host.passwords = nil
 	return nil
 }
 
-func (host *Host) UpdateUser(user *rio.User) error {
+func (host *Host) UpdateUser(ctx context.Context, user *rio.User, moveHome bool) error {
 	host.usersmu.Lock()
 	defer host.usersmu.Unlock()
 
+	if err := host.loadUserGroups(ctx); err != nil {
+		return err
+	}
+
 	old := host.users[user.Name]
 
-	if err := util.UpdateUser(host, old, user); err != nil {
+	if err := util.UpdateUser(ctx, host, old, user, moveHome); err != nil {
 		return err
 	}
 
+	if !util.SameNames(old.Groups, user.Groups) {
+		// Group members changed too: reload both
+		host.users, host.groups = nil, nil
+		return nil
+	}
 	host.users[user.Name] = user
 	return nil
 }
 
-func (host *Host) DeleteUser(name string) error {
+func (host *Host) DeleteUser(ctx context.Context, name string, removeHome bool) error {
 	host.usersmu.Lock()
 	defer host.usersmu.Unlock()
 
-	if err := util.DeleteUser(host, name); err != nil {
+	if err := util.DeleteUser(ctx, host, name, removeHome); err != nil {
 		return err
 	}
 
-	delete(host.users, name)
+	// It's gone from group members too: reload both
+	host.users, host.groups = nil, nil
 	return nil
 }
@@ -0,0 +1,6 @@
+package rio
+
+type Package struct {
+	Name    string
+	Version string // blank means any version (install latest)
+}
@@ -0,0 +1,11 @@
+package remote
+
+import (
+	"context"
+
+	"khan.rip/rio/util"
+)
+
+func (host *Host) Checksum(ctx context.Context, fpath, algo string) (string, error) {
+	return util.Checksum(ctx, host, fpath, algo)
+}
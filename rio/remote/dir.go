@@ -1,9 +1,18 @@
 package remote
 
 import (
+	"context"
+	"log"
+
 	"khan.rip/rio/util"
 )
 
-func (host *Host) MkdirAll(fpath string) error {
-	return util.MkdirAll(host, fpath)
+func (host *Host) MkdirAll(ctx context.Context, fpath string) error {
+	if client := host.sftp(); client != nil {
+		if host.verbose {
+			log.Println(host, "mkdir -p", fpath)
+		}
+		return sftpError("mkdir", fpath, client.MkdirAll(fpath))
+	}
+	return util.MkdirAll(ctx, host, fpath)
 }
@@ -2,6 +2,7 @@ package remote
 
 import (
 	"bytes"
+	"context"
 	//	"fmt"
 	"log"
 	"os"
@@ -36,7 +37,7 @@ func (host *Host) Exec(cmd *rio.Cmd) error {
 	session.Stdout = cmd.Stdout
 	session.Stderr = stderr
 
-	if !cmd.Shell {
+	if !cmd.Shell && host.become == nil {
 		// This doesn't often work-- you would need to set AcceptENV on /etc/ssh/sshd_config
 		// on the server with each var you allow to set. I'm not sure why things are that way.
 		// In shell mode we can work around it with some export statements.
@@ -52,15 +53,33 @@ func (host *Host) Exec(cmd *rio.Cmd) error {
 		cmdline += " " + shell.ReadableEscapeArg(a)
 	}
 
-	if cmd.Shell {
-		exports := "source /etc/profile; "
+	if !cmd.Shell && host.become != nil && len(cmd.Env) > 0 {
+		// sudo and doas scrub the environment, so set it on the other side
+		env := "env"
 		for _, e := range cmd.Env {
-			exports += "export " + shell.ReadableEscapeArg(e[0]) + "=" + shell.ReadableEscapeArg(e[1]) + "; "
+			env += " " + shell.ReadableEscapeArg(e[0]+"="+e[1])
 		}
-		cmdline = "bash -c " + shell.ReadableEscapeArg(exports+cmdline)
+		cmdline = env + " " + cmdline
+	}
+
+	if cmd.Dir != "" {
+		cmdline = "cd " + shell.ReadableEscapeArg(cmd.Dir) + " && " + cmdline
+	}
+
+	if cmd.Shell {
+		cmdline = "bash -c " + shell.ReadableEscapeArg(rio.ShellScript(cmd.Env, cmdline))
 	}
 
-	err = session.Run(cmdline)
+	ctx := cmd.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	err = run(ctx, session, host.wrap(cmdline))
+
+	if err != nil && ctx.Err() != nil {
+		return err
+	}
 
 	if err != nil {
 		// Capture certain stderr responses for programs like rm, stat, chmod, chown, etc
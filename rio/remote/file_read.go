@@ -2,6 +2,7 @@ package remote
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -43,11 +44,15 @@ func (r *Reader) Close() error {
 	return r.closeerr
 }
 
-func (host *Host) Open(path string) (io.ReadCloser, error) {
+func (host *Host) Open(ctx context.Context, path string) (io.ReadCloser, error) {
 	if host.verbose {
 		log.Println(host, "<", path)
 	}
 
+	if client := host.sftp(); client != nil {
+		return sftpOpen(ctx, client, path)
+	}
+
 	reader := &Reader{
 		procerr: make(chan error),
 	}
@@ -67,18 +72,23 @@ func (host *Host) Open(path string) (io.ReadCloser, error) {
 
 	cmdline := "cat " + shell.ReadableEscapeArg(path)
 
-	if err := session.Start(cmdline); err != nil {
+	if err := session.Start(host.wrap(cmdline)); err != nil {
 		w.Close()
 		r.Close()
 		session.Put()
 		return nil, err
 	}
 
+	stop := watch(ctx, session)
+
 	go func() {
 		err := session.Wait()
+		stop()
 		e := strings.TrimSpace(errbuf.String())
 
-		if err != nil {
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		} else if err != nil {
 			if strings.HasPrefix(e, "cat: ") && strings.HasSuffix(e, "No such file or directory") {
 				// emulate os.Open
 				err = &os.PathError{
@@ -104,9 +114,9 @@ func (host *Host) Open(path string) (io.ReadCloser, error) {
 	return reader, nil
 }
 
-func (host *Host) ReadFile(fpath string) ([]byte, error) {
+func (host *Host) ReadFile(ctx context.Context, fpath string) ([]byte, error) {
 	buf := &bytes.Buffer{}
-	fh, err := host.Open(fpath)
+	fh, err := host.Open(ctx, fpath)
 	if err != nil {
 		return nil, err
 	}
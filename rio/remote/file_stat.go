@@ -2,6 +2,7 @@ package remote
 
 import (
 	"bytes"
+	"context"
 	"log"
 	"os"
 	"strings"
@@ -11,13 +12,32 @@ import (
 	"github.com/keegancsmith/shell"
 )
 
-func (host *Host) Stat(path string) (os.FileInfo, error) {
+func (host *Host) Stat(ctx context.Context, path string) (os.FileInfo, error) {
 	if host.verbose {
 		log.Println(host, "stat", path)
 	}
 
+	if client := host.sftp(); client != nil {
+		return sftpStat(client, path, true)
+	}
+	return host.stat(ctx, path, true)
+}
+
+func (host *Host) Lstat(ctx context.Context, path string) (os.FileInfo, error) {
+	if host.verbose {
+		log.Println(host, "lstat", path)
+	}
+
+	if client := host.sftp(); client != nil {
+		return sftpStat(client, path, false)
+	}
+	return host.stat(ctx, path, false)
+}
+
+func (host *Host) stat(ctx context.Context, path string, follow bool) (os.FileInfo, error) {
+
 	// need this to know what args to pass to stat command
-	info, err := host.Info()
+	info, err := host.Info(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -34,17 +54,25 @@ func (host *Host) Stat(path string) (os.FileInfo, error) {
 	session.Stdout = outbuf
 	session.Stderr = errbuf
 
-	statcmd := "stat -t"
-	if info.OS == "openbsd" {
-		statcmd = "stat -r"
-	}
+	statcmd := util.StatCommand(info.OS, follow)
 
 	cmdline := statcmd + " " + shell.ReadableEscapeArg(path)
 
-	err = session.Run(cmdline)
+	err = run(ctx, session, host.wrap(cmdline))
 
 	outstr := strings.TrimSpace(outbuf.String())
 	errstr := strings.TrimSpace(errbuf.String())
 
 	return util.ParseStat(info.OS, path, outstr, errstr, err)
 }
+
+func (host *Host) Readlink(ctx context.Context, path string) (string, error) {
+	if client := host.sftp(); client != nil {
+		if host.verbose {
+			log.Println(host, "readlink", path)
+		}
+		target, err := client.ReadLink(path)
+		return target, sftpError("readlink", path, err)
+	}
+	return util.Readlink(ctx, host, path)
+}
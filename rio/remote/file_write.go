@@ -2,6 +2,7 @@ package remote
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -43,11 +44,15 @@ func (w *Writer) Close() error {
 	return w.closeerr
 }
 
-func (host *Host) Create(path string) (io.WriteCloser, error) {
+func (host *Host) Create(ctx context.Context, path string) (io.WriteCloser, error) {
 	if host.verbose {
 		log.Println(host, ">", path)
 	}
 
+	if client := host.sftp(); client != nil {
+		return sftpCreate(ctx, client, path)
+	}
+
 	session, err := host.pool.Get(host.connect)
 	if err != nil {
 		return nil, err
@@ -66,23 +71,33 @@ func (host *Host) Create(path string) (io.WriteCloser, error) {
 
 	cmdline := "cat > " + shell.ReadableEscapeArg(path)
 
-	if err := session.Start(cmdline); err != nil {
+	if err := session.Start(host.wrap(cmdline)); err != nil {
 		w.Close()
 		r.Close()
 		session.Put()
 		return nil, err
 	}
 
+	stop := watch(ctx, session)
+
 	go func() {
 		err := session.Wait()
+		stop()
 		e := strings.TrimSpace(errbuf.String())
 
-		if err != nil {
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		} else if err != nil {
 			// Bundle up stderr and hope it's useful
 			err = fmt.Errorf("Command %#v on %#v: %w: %s",
 				cmdline, host.connect, err, e)
 		}
 
+		if err != nil {
+			// Unblock any writes the remote end will never read
+			r.CloseWithError(err)
+		}
+
 		writer.procerr <- err
 		close(writer.procerr)
 		session.Put()
@@ -91,18 +106,56 @@ func (host *Host) Create(path string) (io.WriteCloser, error) {
 	return writer, nil
 }
 
-func (host *Host) Remove(fpath string) error {
-	return util.Remove(host, fpath)
+func (host *Host) Remove(ctx context.Context, fpath string) error {
+	if client := host.sftp(); client != nil {
+		if host.verbose {
+			log.Println(host, "rm", fpath)
+		}
+		return sftpError("remove", fpath, client.Remove(fpath))
+	}
+	return util.Remove(ctx, host, fpath)
+}
+
+func (host *Host) Rename(ctx context.Context, oldpath, newpath string) error {
+	// Plain SFTP rename won't replace newpath like mv does
+	if client := host.sftp(); client != nil {
+		if _, ok := client.HasExtension("posix-rename@openssh.com"); ok {
+			if host.verbose {
+				log.Println(host, "mv", oldpath, newpath)
+			}
+			return sftpError("rename", oldpath, client.PosixRename(oldpath, newpath))
+		}
+	}
+	return util.Rename(ctx, host, oldpath, newpath)
+}
+
+func (host *Host) Chown(ctx context.Context, fpath string, uid uint32, gid uint32) error {
+	if client := host.sftp(); client != nil {
+		if host.verbose {
+			log.Println(host, "chown", uid, gid, fpath)
+		}
+		return sftpError("chown", fpath, client.Chown(fpath, int(uid), int(gid)))
+	}
+	return util.Chown(ctx, host, fpath, uid, gid)
 }
 
-func (host *Host) Rename(oldpath, newpath string) error {
-	return util.Rename(host, oldpath, newpath)
+// Lchown and Symlink stay on the shell: OpenSSH's sftp-server has no lchown,
+// and takes symlink arguments in the opposite order from the spec.
+
+func (host *Host) Lchown(ctx context.Context, fpath string, uid uint32, gid uint32) error {
+	return util.Lchown(ctx, host, fpath, uid, gid)
 }
 
-func (host *Host) Chown(fpath string, uid uint32, gid uint32) error {
-	return util.Chown(host, fpath, uid, gid)
+func (host *Host) Symlink(ctx context.Context, target, fpath string) error {
+	return util.Symlink(ctx, host, target, fpath)
 }
 
-func (host *Host) Chmod(fpath string, perms os.FileMode) error {
-	return util.Chmod(host, fpath, perms)
+func (host *Host) Chmod(ctx context.Context, fpath string, perms os.FileMode) error {
+	if client := host.sftp(); client != nil {
+		if host.verbose {
+			log.Printf("%s chmod %o %s", host, perms, fpath)
+		}
+		return sftpError("chmod", fpath, client.Chmod(fpath, perms))
+	}
+	return util.Chmod(ctx, host, fpath, perms)
 }
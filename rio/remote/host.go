@@ -13,6 +13,9 @@ type Host struct {
 
 	pool    *sshpool.Pool
 	connect string
+	name    string
+
+	become *rio.Become
 
 	infomu sync.Mutex
 	info   *rio.Info
@@ -22,14 +25,45 @@ type Host struct {
 	groups    map[string]*rio.Group
 	passwords map[string]*rio.Password
 
+	servicesmu sync.Mutex
+	services   map[string]*rio.Service
+
+	packagesmu sync.Mutex
+	packages   map[string]*rio.Package
+
 	tmpdirmu sync.Mutex
 	tmpdir   string
+
+	sftpconn sftpconn
 }
 
 func (host *Host) String() string {
+	if host.name != "" {
+		return "ssh " + host.name
+	}
 	return "ssh " + host.connect
 }
 
+// SetBecome makes all commands and file access go through sudo or doas.
+// Call it before using the host.
+func (host *Host) SetBecome(b *rio.Become) {
+	host.become = b
+}
+
+// wrap applies become, if set, to a command line
+func (host *Host) wrap(cmdline string) string {
+	if host.become == nil {
+		return cmdline
+	}
+	return host.become.Wrap(cmdline)
+}
+
+// SetName sets what String shows, for when the pool connect string is not
+// what the user asked for (ssh config aliases, jump host forwarding).
+func (host *Host) SetName(name string) {
+	host.name = name
+}
+
 func (host *Host) SetVerbose() {
 	host.verbose = true
 }
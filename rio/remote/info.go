@@ -2,13 +2,16 @@ package remote
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"khan.rip/rio"
+	"khan.rip/rio/util"
 )
 
-func (host *Host) Info() (*rio.Info, error) {
+func (host *Host) Info(ctx context.Context) (*rio.Info, error) {
 	host.infomu.Lock()
 	defer host.infomu.Unlock()
 
@@ -28,7 +31,7 @@ func (host *Host) Info() (*rio.Info, error) {
 
 	cmdline := "uname -a"
 
-	if err := session.Run(cmdline); err != nil {
+	if err := run(ctx, session, cmdline); err != nil {
 		return nil, err
 	}
 
@@ -54,9 +57,62 @@ func (host *Host) Info() (*rio.Info, error) {
 	}
 
 	// try to make like GOARCH
-	if info.Arch == "x86_64" {
+	switch info.Arch {
+	case "x86_64":
 		info.Arch = "amd64"
+	case "aarch64":
+		info.Arch = "arm64"
 	}
 
+	if err := host.identity(ctx, info); err != nil {
+		return nil, err
+	}
+
+	if info.OS == "linux" {
+		session, err := host.pool.Get(host.connect)
+		if err != nil {
+			return nil, err
+		}
+		defer session.Put()
+
+		outbuf := &bytes.Buffer{}
+		session.Stdout = outbuf
+
+		// Not fatal: some minimal systems don't have this file.
+		if err := run(ctx, session, "cat /etc/os-release"); err == nil {
+			if info.Distro, info.DistroLike, err = util.ParseOSRelease(outbuf); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	host.info = info
+
 	return info, nil
 }
+
+// identity fills in the effective user. With become this also checks early
+// that sudo or doas works without a password.
+func (host *Host) identity(ctx context.Context, info *rio.Info) error {
+	buf := &bytes.Buffer{}
+	cmd := rio.ReadOnlyCommand(ctx, "id", "-u")
+	cmd.Stdout = buf
+	if err := host.Exec(cmd); err != nil {
+		return err
+	}
+	uid, err := strconv.ParseUint(strings.TrimSpace(buf.String()), 10, 32)
+	if err != nil {
+		return fmt.Errorf("Failed to parse id -u: %w", err)
+	}
+
+	buf.Reset()
+	cmd = rio.ReadOnlyCommand(ctx, "id", "-un")
+	cmd.Stdout = buf
+	if err := host.Exec(cmd); err != nil {
+		return err
+	}
+
+	info.Uid = uint32(uid)
+	info.User = strings.TrimSpace(buf.String())
+	return nil
+}
@@ -0,0 +1,118 @@
+package remote
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type JumpHop struct {
+	Addr   string // host:port
+	Config *ssh.ClientConfig
+}
+
+// DialJump connects through a chain of jump hosts, like ssh -J. The
+// returned client is connected to the last hop.
+func DialJump(hops []JumpHop) (*ssh.Client, error) {
+	var client *ssh.Client
+	for _, hop := range hops {
+		if client == nil {
+			c, err := ssh.Dial("tcp", hop.Addr, hop.Config)
+			if err != nil {
+				return nil, fmt.Errorf("ssh dial jump host %#v: %w", hop.Addr, err)
+			}
+			client = c
+			continue
+		}
+		conn, err := client.Dial("tcp", hop.Addr)
+		if err != nil {
+			client.Close()
+			return nil, fmt.Errorf("ssh dial jump host %#v: %w", hop.Addr, err)
+		}
+		c, chans, reqs, err := ssh.NewClientConn(conn, hop.Addr, hop.Config)
+		if err != nil {
+			conn.Close()
+			client.Close()
+			return nil, fmt.Errorf("ssh dial jump host %#v: %w", hop.Addr, err)
+		}
+		client = ssh.NewClient(c, chans, reqs)
+	}
+	return client, nil
+}
+
+// Jump listens on a local port and forwards one connection to target
+// through an SSH client. sshpool can only dial TCP addresses itself, so
+// hosts behind a bastion are reached by pointing the pool at Addr(). Anyone
+// on this machine could connect to the port, so it stops listening as soon
+// as the first connection comes in: dial it right away, and only once.
+type Jump struct {
+	listener net.Listener
+	client   *ssh.Client
+	target   string
+}
+
+func NewJump(client *ssh.Client, target string) (*Jump, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	j := &Jump{
+		listener: listener,
+		client:   client,
+		target:   target,
+	}
+	go j.accept()
+	return j, nil
+}
+
+// Addr is the local host:port to connect to
+func (j *Jump) Addr() string {
+	return j.listener.Addr().String()
+}
+
+// Close stops listening if it still is. The SSH client is left open since
+// it may be shared.
+func (j *Jump) Close() error {
+	err := j.listener.Close()
+	if err != nil && strings.Contains(err.Error(), "use of closed network connection") {
+		return nil
+	}
+	return err
+}
+
+func (j *Jump) accept() {
+	conn, err := j.listener.Accept()
+	j.listener.Close()
+	if err != nil {
+		if !strings.Contains(err.Error(), "use of closed network connection") {
+			fmt.Fprintf(os.Stderr, "jump to %s: %v\n", j.target, err)
+		}
+		return
+	}
+	j.forward(conn)
+}
+
+func (j *Jump) forward(local net.Conn) {
+	defer local.Close()
+
+	remote, err := j.client.Dial("tcp", j.target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "jump to %s: %v\n", j.target, err)
+		return
+	}
+	defer remote.Close()
+
+	done := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(remote, local)
+		remote.Close()
+		close(done)
+	}()
+	_, _ = io.Copy(local, remote)
+	local.Close()
+	<-done
+}
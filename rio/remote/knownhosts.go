@@ -0,0 +1,228 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Host key checking modes, like OpenSSH StrictHostKeyChecking
+const (
+	HostKeyStrict    = "strict"     // Unknown hosts and mismatched keys are errors
+	HostKeyAcceptNew = "accept-new" // Unknown hosts are added to known_hosts; mismatched keys are errors
+	HostKeyOff       = "off"        // No checking at all
+)
+
+// DefaultKnownHosts returns ~/.ssh/known_hosts
+func DefaultKnownHosts() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".ssh", "known_hosts"), nil
+}
+
+// HostKeyCallback verifies host keys against an OpenSSH known_hosts file.
+// A blank file means ~/.ssh/known_hosts.
+func HostKeyCallback(mode, file string) (ssh.HostKeyCallback, error) {
+	hk, err := NewHostKeys(mode, file)
+	if err != nil {
+		return nil, err
+	}
+	return hk.Check, nil
+}
+
+// HostKeys checks host keys against a known_hosts file.
+type HostKeys struct {
+	mode  string
+	file  string
+	check ssh.HostKeyCallback
+
+	// Keys added during this run, since check doesn't see them
+	mu       sync.Mutex
+	accepted map[string]string
+}
+
+func NewHostKeys(mode, file string) (*HostKeys, error) {
+	switch mode {
+	case HostKeyOff:
+		return &HostKeys{mode: mode}, nil
+	case HostKeyStrict, HostKeyAcceptNew:
+	default:
+		return nil, fmt.Errorf("Unknown host key check mode %#v: Expected %s, %s or %s", mode, HostKeyStrict, HostKeyAcceptNew, HostKeyOff)
+	}
+
+	if file == "" {
+		var err error
+		file, err = DefaultKnownHosts()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if mode == HostKeyAcceptNew {
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+				return nil, err
+			}
+			fh, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY, 0600)
+			if err != nil {
+				return nil, err
+			}
+			if err := fh.Close(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	kh, err := knownhosts.New(file)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load known hosts: %w", err)
+	}
+
+	return &HostKeys{
+		mode:     mode,
+		file:     file,
+		check:    kh,
+		accepted: map[string]string{},
+	}, nil
+}
+
+// CallbackFor checks keys as belonging to hostport (host:port) no matter
+// what address was dialed. Used for hosts reached through a local jump
+// forwarder.
+func (hk *HostKeys) CallbackFor(hostport string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		return hk.Check(hostport, remote, key)
+	}
+}
+
+// probekey never matches anything. Checking it gets the list of known keys.
+type probekey struct{}
+
+func (probekey) Type() string                        { return "khan-probe" }
+func (probekey) Marshal() []byte                     { return []byte("khan-probe") }
+func (probekey) Verify([]byte, *ssh.Signature) error { return errors.New("probe key") }
+
+// Algorithms returns the host key algorithms to offer for hostport, so the
+// server doesn't pick a key type we have no record of. nil means the
+// defaults, for unknown hosts or when checking is off.
+func (hk *HostKeys) Algorithms(hostport string) []string {
+	if hk.mode == HostKeyOff {
+		return nil
+	}
+
+	known := map[string]bool{}
+
+	var keyerr *knownhosts.KeyError
+	if err := hk.check(hostport, &net.TCPAddr{}, probekey{}); errors.As(err, &keyerr) {
+		for _, want := range keyerr.Want {
+			known[want.Key.Type()] = true
+		}
+	}
+
+	hk.mu.Lock()
+	if line, ok := hk.accepted[knownhosts.Normalize(hostport)]; ok {
+		if fields := strings.Fields(line); len(fields) > 1 {
+			known[fields[1]] = true
+		}
+	}
+	hk.mu.Unlock()
+
+	var r []string
+	for _, algo := range []string{
+		ssh.KeyAlgoED25519,
+		ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoRSA, ssh.KeyAlgoDSA,
+	} {
+		if known[algo] {
+			r = append(r, algo)
+		}
+	}
+	return r
+}
+
+// Check is an ssh.HostKeyCallback
+func (hk *HostKeys) Check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if hk.mode == HostKeyOff {
+		return nil
+	}
+
+	err := hk.check(hostname, remote, key)
+	if err == nil {
+		return nil
+	}
+
+	var keyerr *knownhosts.KeyError
+	if !errors.As(err, &keyerr) {
+		return err
+	}
+
+	fp := key.Type() + " " + ssh.FingerprintSHA256(key)
+
+	if len(keyerr.Want) > 0 {
+		// Known host, different key
+		known := keyerr.Want[0]
+		for _, want := range keyerr.Want {
+			if want.Key.Type() == key.Type() {
+				known = want
+				break
+			}
+		}
+		if known.Key.Type() != key.Type() {
+			return fmt.Errorf("Host key for %s is %s, but %s:%d only has a %s key for it. Add the new key type if this is expected",
+				hostname, fp, known.Filename, known.Line, known.Key.Type())
+		}
+		return fmt.Errorf("Host key for %s has changed to %s! This could be a MITM attack. Known key is %s:%d. Remove it if the host was reinstalled",
+			hostname, fp, known.Filename, known.Line)
+	}
+
+	// Unknown host
+	if hk.mode != HostKeyAcceptNew {
+		return fmt.Errorf("Host key for %s (%s) is not in %s. Add it with ssh-keyscan or use accept-new host key checking",
+			hostname, fp, hk.file)
+	}
+
+	addr := knownhosts.Normalize(hostname)
+	line := knownhosts.Line([]string{addr}, key)
+
+	hk.mu.Lock()
+	defer hk.mu.Unlock()
+
+	if accepted, ok := hk.accepted[addr]; ok {
+		if accepted == line {
+			return nil
+		}
+		return fmt.Errorf("Host key for %s changed to %s during this run! This could be a MITM attack", hostname, fp)
+	}
+
+	fh, err := os.OpenFile(hk.file, os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	// Don't glue our line onto the end of one without a newline
+	if fi, err := fh.Stat(); err == nil && fi.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := fh.ReadAt(last, fi.Size()-1); err == nil && last[0] != '\n' {
+			line = "\n" + line
+		}
+	}
+	if _, err := fmt.Fprintln(fh, line); err != nil {
+		fh.Close()
+		return err
+	}
+	if err := fh.Close(); err != nil {
+		return err
+	}
+	hk.accepted[addr] = knownhosts.Line([]string{addr}, key)
+
+	fmt.Fprintf(os.Stderr, "Added %s (%s) to %s\n", addr, fp, hk.file)
+	return nil
+}
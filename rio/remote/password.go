@@ -1,17 +1,18 @@
 package remote
 
 import (
+	"context"
 	"khan.rip/rio"
 	"khan.rip/rio/util"
 )
 
-func (host *Host) Password(name string) (*rio.Password, error) {
+func (host *Host) Password(ctx context.Context, name string) (*rio.Password, error) {
 	host.usersmu.Lock()
 	defer host.usersmu.Unlock()
 
 	if host.passwords == nil {
 		var err error
-		host.passwords, err = util.LoadPasswords(host)
+		host.passwords, err = util.LoadPasswords(ctx, host)
 		if err != nil {
 			return nil, err
 		}
@@ -20,13 +21,21 @@ func (host *Host) Password(name string) (*rio.Password, error) {
 	return host.passwords[name], nil
 }
 
-func (host *Host) UpdatePassword(password *rio.Password) error {
+func (host *Host) UpdatePassword(ctx context.Context, password *rio.Password) error {
 	host.usersmu.Lock()
 	defer host.usersmu.Unlock()
 
+	if host.passwords == nil {
+		var err error
+		host.passwords, err = util.LoadPasswords(ctx, host)
+		if err != nil {
+			return err
+		}
+	}
+
 	old := host.passwords[password.Name]
 
-	if err := util.UpdatePassword(host, old, password); err != nil {
+	if err := util.UpdatePassword(ctx, host, old, password); err != nil {
 		return err
 	}
 
@@ -0,0 +1,59 @@
+package remote
+
+import (
+	"context"
+	"khan.rip/rio"
+	"khan.rip/rio/util"
+)
+
+func (host *Host) Service(ctx context.Context, name string) (*rio.Service, error) {
+	host.servicesmu.Lock()
+	defer host.servicesmu.Unlock()
+
+	if host.services == nil || host.services[name] == nil {
+		// Reload on a miss: a package or unit file may have shown up since we last looked.
+		var err error
+		host.services, err = util.LoadServices(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return host.services[name], nil
+}
+
+func (host *Host) UpdateService(ctx context.Context, service *rio.Service) error {
+	host.servicesmu.Lock()
+	defer host.servicesmu.Unlock()
+
+	old := host.services[service.Unit]
+
+	if err := util.UpdateService(ctx, host, old, service); err != nil {
+		return err
+	}
+
+	host.services[service.Unit] = service
+	return nil
+}
+
+func (host *Host) RestartService(ctx context.Context, name string) error {
+	host.servicesmu.Lock()
+	defer host.servicesmu.Unlock()
+
+	if err := util.RestartService(ctx, host, name); err != nil {
+		return err
+	}
+
+	if s := host.services[name]; s != nil {
+		s.Active = "active"
+		s.Sub = "running"
+	}
+	return nil
+}
+
+func (host *Host) ReloadService(ctx context.Context, name string) error {
+	host.servicesmu.Lock()
+	defer host.servicesmu.Unlock()
+
+	return util.ReloadService(ctx, host, name)
+}
@@ -0,0 +1,39 @@
+package remote
+
+import (
+	"context"
+
+	"github.com/desops/sshpool"
+	"golang.org/x/crypto/ssh"
+)
+
+// watch interrupts the remote process and closes the session if ctx is
+// canceled before the returned stop function is called.
+func watch(ctx context.Context, session *sshpool.Session) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = session.Signal(ssh.SIGINT)
+			_ = session.Close()
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+// run is session.Run, but gives up when ctx is canceled.
+func run(ctx context.Context, session *sshpool.Session, cmdline string) error {
+	if err := session.Start(cmdline); err != nil {
+		return err
+	}
+	stop := watch(ctx, session)
+	err := session.Wait()
+	stop()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,235 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"khan.rip/rio/util"
+
+	"github.com/desops/sshpool"
+	"github.com/pkg/sftp"
+)
+
+// The file half of the host goes over one long lived SFTP session when the
+// server has the sftp subsystem: no session per operation, and missing files
+// come back as status codes instead of localized stderr. Without the
+// subsystem, and in become mode (sftp-server runs as the login user), the
+// shell commands are used instead.
+
+type sftpconn struct {
+	mu      sync.Mutex
+	tried   bool
+	session *sshpool.Session
+	client  *sftp.Client
+}
+
+// sftp returns the host's SFTP client, starting it on first use, or nil if
+// file access should go through shell commands.
+func (host *Host) sftp() *sftp.Client {
+	if host.become != nil {
+		return nil
+	}
+
+	sc := &host.sftpconn
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.tried {
+		return sc.client
+	}
+
+	session, err := host.pool.Get(host.connect)
+	if err != nil {
+		// Leave it untried: the shell path will report the connection error
+		return nil
+	}
+	sc.tried = true
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Put()
+		return nil
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Put()
+		return nil
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		if host.verbose {
+			log.Println(host, "no sftp subsystem, using shell commands:", err)
+		}
+		session.Close()
+		session.Put()
+		return nil
+	}
+	client, err := sftp.NewClientPipe(stdout, stdin)
+	if err != nil {
+		if host.verbose {
+			log.Println(host, "sftp failed, using shell commands:", err)
+		}
+		session.Close()
+		session.Put()
+		return nil
+	}
+
+	sc.session = session
+	sc.client = client
+	return client
+}
+
+func (host *Host) closeSFTP() {
+	sc := &host.sftpconn
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.client != nil {
+		sc.client.Close()
+		sc.session.Close()
+		sc.session.Put()
+		sc.client = nil
+		sc.session = nil
+	}
+}
+
+// sftpError emulates the os package errors, so util.IsErrNotFound and
+// friends work the same as over the shell.
+func sftpError(op, fpath string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return &os.PathError{Op: op, Path: fpath, Err: syscall.ENOENT}
+	}
+	if errors.Is(err, os.ErrPermission) {
+		return &os.PathError{Op: op, Path: fpath, Err: syscall.EACCES}
+	}
+	var perr *os.PathError
+	if errors.As(err, &perr) {
+		return err
+	}
+	return &os.PathError{Op: op, Path: fpath, Err: err}
+}
+
+func sftpStat(client *sftp.Client, fpath string, follow bool) (os.FileInfo, error) {
+	stat, op := client.Lstat, "lstat"
+	if follow {
+		stat, op = client.Stat, "stat"
+	}
+	fi, err := stat(fpath)
+	if err != nil {
+		return nil, sftpError(op, fpath, err)
+	}
+	st, ok := fi.Sys().(*sftp.FileStat)
+	if !ok {
+		return nil, &os.PathError{Op: op, Path: fpath, Err: errors.New("No attributes from sftp server")}
+	}
+	// Same raw st_mode as the stat command gives us
+	return &util.FileInfo{
+		Fname:    fpath,
+		Fsize:    int64(st.Size),
+		Fmode:    os.FileMode(st.Mode),
+		Fmodtime: time.Unix(int64(st.Mtime), 0),
+		Fisdir:   st.Mode&util.S_ifmt == util.S_ifdir,
+		Fislink:  st.Mode&util.S_ifmt == util.S_iflnk,
+		Fuid:     st.UID,
+		Fgid:     st.GID,
+	}, nil
+}
+
+// sftpFile is an open remote file. Canceling ctx closes it, which fails any
+// read or write in flight.
+type sftpFile struct {
+	file *sftp.File
+	ctx  context.Context
+	op   string
+	path string
+
+	closemu sync.Mutex
+	closed  bool
+	done    chan struct{}
+}
+
+func newSFTPFile(ctx context.Context, file *sftp.File, op, fpath string) *sftpFile {
+	f := &sftpFile{
+		file: file,
+		ctx:  ctx,
+		op:   op,
+		path: fpath,
+		done: make(chan struct{}),
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = f.Close()
+		case <-f.done:
+		}
+	}()
+	return f
+}
+
+func (f *sftpFile) err(err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	if f.ctx.Err() != nil {
+		return f.ctx.Err()
+	}
+	return sftpError(f.op, f.path, err)
+}
+
+func (f *sftpFile) Read(p []byte) (int, error) {
+	n, err := f.file.Read(p)
+	return n, f.err(err)
+}
+
+func (f *sftpFile) Write(p []byte) (int, error) {
+	n, err := f.file.Write(p)
+	return n, f.err(err)
+}
+
+// ReadFrom lets io.Copy use sftp's pipelined writes
+func (f *sftpFile) ReadFrom(r io.Reader) (int64, error) {
+	n, err := f.file.ReadFrom(r)
+	return n, f.err(err)
+}
+
+// WriteTo lets io.Copy use sftp's pipelined reads
+func (f *sftpFile) WriteTo(w io.Writer) (int64, error) {
+	n, err := f.file.WriteTo(w)
+	return n, f.err(err)
+}
+
+func (f *sftpFile) Close() error {
+	f.closemu.Lock()
+	defer f.closemu.Unlock()
+
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	close(f.done)
+	return f.err(f.file.Close())
+}
+
+func sftpOpen(ctx context.Context, client *sftp.Client, fpath string) (io.ReadCloser, error) {
+	file, err := client.Open(fpath)
+	if err != nil {
+		return nil, sftpError("open", fpath, err)
+	}
+	return newSFTPFile(ctx, file, "read", fpath), nil
+}
+
+func sftpCreate(ctx context.Context, client *sftp.Client, fpath string) (io.WriteCloser, error) {
+	file, err := client.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return nil, sftpError("create", fpath, err)
+	}
+	return newSFTPFile(ctx, file, "write", fpath), nil
+}
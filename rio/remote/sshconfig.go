@@ -0,0 +1,157 @@
+package remote
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/kevinburke/ssh_config"
+)
+
+// Destination is a connect string resolved through ~/.ssh/config
+type Destination struct {
+	Alias    string // Host as given, matched against Host patterns
+	User     string
+	HostName string
+	Port     string
+
+	// IdentityFiles are from the config. Blank means OpenSSH's defaults.
+	IdentityFiles []string
+
+	// ProxyJump hops, outermost first, in [user@]host[:port] form
+	ProxyJump []string
+}
+
+// Addr returns host:port for dialing and known_hosts
+func (d *Destination) Addr() string {
+	return net.JoinHostPort(d.HostName, d.Port)
+}
+
+// Connect returns the user@host:port form sshpool wants
+func (d *Destination) Connect() string {
+	return d.User + "@" + d.Addr()
+}
+
+// splitConnect splits [user@]host[:port]
+func splitConnect(connect string) (string, string, string) {
+	var login, port string
+	host := connect
+	if at := strings.LastIndexByte(host, '@'); at > -1 {
+		login = host[:at]
+		host = host[at+1:]
+	}
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host, port = h, p
+	}
+	return login, host, port
+}
+
+// ResolveSSHConfig fills in User, HostName, Port, IdentityFile and ProxyJump
+// for a connect string from the user's ~/.ssh/config and the system
+// ssh_config. Anything in the connect string itself wins.
+func ResolveSSHConfig(connect string) (*Destination, error) {
+	login, alias, port := splitConnect(connect)
+
+	get := func(key string) (string, error) {
+		v, err := ssh_config.GetStrict(alias, key)
+		if err != nil {
+			return "", fmt.Errorf("ssh config: %w", err)
+		}
+		return v, nil
+	}
+
+	d := &Destination{
+		Alias: alias,
+		User:  login,
+		Port:  port,
+	}
+
+	hostname, err := get("HostName")
+	if err != nil {
+		return nil, err
+	}
+	if hostname == "" {
+		hostname = alias
+	}
+	d.HostName = strings.ReplaceAll(hostname, "%h", alias)
+
+	if d.User == "" {
+		if d.User, err = get("User"); err != nil {
+			return nil, err
+		}
+	}
+	if d.User == "" {
+		u, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		d.User = u.Username
+	}
+
+	if d.Port == "" {
+		if d.Port, err = get("Port"); err != nil {
+			return nil, err
+		}
+	}
+	if d.Port == "" {
+		d.Port = "22"
+	}
+
+	idfiles, err := ssh_config.GetAllStrict(alias, "IdentityFile")
+	if err != nil {
+		return nil, fmt.Errorf("ssh config: %w", err)
+	}
+	for _, f := range idfiles {
+		if f == ssh_config.Default("IdentityFile") {
+			// The library returns this when nothing is configured
+			continue
+		}
+		d.IdentityFiles = append(d.IdentityFiles, expandPath(f, d))
+	}
+
+	jump, err := get("ProxyJump")
+	if err != nil {
+		return nil, err
+	}
+	if jump != "" && jump != "none" {
+		for _, hop := range strings.Split(jump, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				d.ProxyJump = append(d.ProxyJump, strings.TrimPrefix(hop, "ssh://"))
+			}
+		}
+	}
+
+	return d, nil
+}
+
+// expandPath handles ~ and the common % tokens in IdentityFile
+func expandPath(p string, d *Destination) string {
+	home, _ := os.UserHomeDir()
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		p = filepath.Join(home, p[1:])
+	}
+	return strings.NewReplacer(
+		"%d", home,
+		"%h", d.HostName,
+		"%n", d.Alias,
+		"%p", d.Port,
+		"%r", d.User,
+		"%%", "%",
+	).Replace(p)
+}
+
+// DefaultIdentityFiles are the keys OpenSSH tries when none are configured
+func DefaultIdentityFiles() []string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	var r []string
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa", "id_dsa"} {
+		r = append(r, filepath.Join(home, ".ssh", name))
+	}
+	return r
+}
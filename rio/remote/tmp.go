@@ -9,13 +9,12 @@ import (
 	"khan.rip/rio/util"
 )
 
-func (host *Host) TmpFile() (string, error) {
-	tmpdir, err := host.TmpDir()
+func (host *Host) TmpFile(ctx context.Context) (string, error) {
+	tmpdir, err := host.TmpDir(ctx)
 	if err != nil {
 		return "", err
 	}
 
-	ctx := context.Background()
 	cmd := rio.Command(ctx, "mktemp", "-p", tmpdir, "XXXXXXXX")
 
 	buf := &bytes.Buffer{}
@@ -28,7 +27,7 @@ func (host *Host) TmpFile() (string, error) {
 	return strings.TrimSpace(buf.String()), nil
 }
 
-func (host *Host) TmpDir() (string, error) {
+func (host *Host) TmpDir(ctx context.Context) (string, error) {
 	host.tmpdirmu.Lock()
 	defer host.tmpdirmu.Unlock()
 
@@ -36,7 +35,6 @@ func (host *Host) TmpDir() (string, error) {
 		return host.tmpdir, nil
 	}
 
-	ctx := context.Background()
 	cmd := rio.Command(ctx, "mktemp", "-d", "/tmp/tmpkhan_XXXXXXXX")
 
 	buf := &bytes.Buffer{}
@@ -52,13 +50,17 @@ func (host *Host) TmpDir() (string, error) {
 }
 
 func (host *Host) Cleanup() error {
+	ctx := context.Background()
+
+	defer host.closeSFTP()
+
 	host.tmpdirmu.Lock()
 	defer host.tmpdirmu.Unlock()
 
 	if host.tmpdir == "" {
 		return nil
 	}
-	if err := util.RemoveAll(host, host.tmpdir); err != nil {
+	if err := util.RemoveAll(ctx, host, host.tmpdir); err != nil {
 		return err
 	}
 	return nil
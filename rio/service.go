@@ -7,4 +7,20 @@ type Service struct {
 	Active      string `json:"active"`
 	Sub         string `json:"sub"`
 	Description string `json:"description"`
+
+	// State is the unit file state from systemctl list-unit-files
+	// (enabled, disabled, static, masked, etc.)
+	State string `json:"state"`
+}
+
+func (s *Service) Running() bool {
+	switch s.Active {
+	case "active", "activating", "reloading":
+		return true
+	}
+	return false
+}
+
+func (s *Service) Enabled() bool {
+	return s.State == "enabled"
 }
@@ -13,9 +13,34 @@ type User struct {
 type Password struct {
 	Name  string
 	Crypt string
+
+	// Aging, in days as in /etc/shadow. -1 is an empty field: no limit.
+	// OpenBSD only has Expire.
+	LastChange   int
+	MinDays      int
+	MaxDays      int
+	WarnDays     int
+	InactiveDays int
+	Expire       int // days since 1970-01-01
+}
+
+// NoAging is a Password with empty aging fields, as useradd makes them when
+// /etc/login.defs doesn't say otherwise
+func NoAging(name, crypt string) *Password {
+	return &Password{
+		Name:         name,
+		Crypt:        crypt,
+		LastChange:   -1,
+		MinDays:      -1,
+		MaxDays:      -1,
+		WarnDays:     -1,
+		InactiveDays: -1,
+		Expire:       -1,
+	}
 }
 
 type Group struct {
-	Name string
-	Gid  uint32
+	Name    string
+	Gid     uint32
+	Members []string // supplementary members, from /etc/group
 }
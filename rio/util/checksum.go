@@ -0,0 +1,60 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+
+	"khan.rip/rio"
+)
+
+// Checksum runs sha256sum (or sha256 -q on OpenBSD) so only the sum comes
+// back over the wire, not the file.
+func Checksum(ctx context.Context, host rio.Host, fpath, algo string) (string, error) {
+	h, err := rio.NewHash(algo)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := host.Info(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var cmd *rio.Cmd
+	if info.OS == "openbsd" {
+		cmd = rio.ReadOnlyCommand(ctx, algo, "-q", fpath)
+	} else {
+		cmd = rio.ReadOnlyCommand(ctx, algo+"sum", fpath)
+	}
+
+	outbuf := &bytes.Buffer{}
+	errbuf := &bytes.Buffer{}
+	cmd.Stdout = outbuf
+	cmd.Stderr = errbuf
+
+	if err := host.Exec(cmd); err != nil {
+		if strings.HasSuffix(strings.TrimSpace(errbuf.String()), "No such file or directory") {
+			return "", &os.PathError{
+				Op:   "checksum",
+				Path: fpath,
+				Err:  syscall.ENOENT,
+			}
+		}
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(errbuf.String()))
+	}
+
+	fields := strings.Fields(outbuf.String())
+	if len(fields) == 0 {
+		return "", fmt.Errorf("No output from %s", cmd)
+	}
+	sum := strings.ToLower(fields[0])
+	if b, err := hex.DecodeString(sum); err != nil || len(b) != h.Size() {
+		return "", fmt.Errorf("Cannot parse %s output: %#v", cmd, outbuf.String())
+	}
+	return sum, nil
+}
@@ -8,39 +8,34 @@ import (
 	"khan.rip/rio"
 )
 
-func Remove(host rio.Host, fpath string) error {
-	ctx := context.Background()
+func Remove(ctx context.Context, host rio.Host, fpath string) error {
 	if err := host.Exec(rio.Command(ctx, "rm", fpath)); err != nil {
 		return err
 	}
 	return nil
 }
 
-func RemoveAll(host rio.Host, fpath string) error {
-	ctx := context.Background()
+func RemoveAll(ctx context.Context, host rio.Host, fpath string) error {
 	if err := host.Exec(rio.Command(ctx, "rm", "-rf", fpath)); err != nil {
 		return err
 	}
 	return nil
 }
 
-func Rename(host rio.Host, oldpath, newpath string) error {
-	ctx := context.Background()
+func Rename(ctx context.Context, host rio.Host, oldpath, newpath string) error {
 	if err := host.Exec(rio.Command(ctx, "mv", oldpath, newpath)); err != nil {
 		return err
 	}
 	return nil
 }
 
-func Mkdir(host rio.Host, fpath string) error {
-	ctx := context.Background()
+func Mkdir(ctx context.Context, host rio.Host, fpath string) error {
 	if err := host.Exec(rio.Command(ctx, "mkdir", fpath)); err != nil {
 		return err
 	}
 	return nil
 }
-func MkdirAll(host rio.Host, fpath string) error {
-	ctx := context.Background()
+func MkdirAll(ctx context.Context, host rio.Host, fpath string) error {
 	return host.Exec(rio.Command(ctx, "mkdir", "-p", fpath))
 }
 
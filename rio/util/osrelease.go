@@ -0,0 +1,32 @@
+package util
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// ParseOSRelease parses /etc/os-release and returns the ID and ID_LIKE values.
+func ParseOSRelease(r io.Reader) (string, []string, error) {
+	var (
+		id   string
+		like []string
+	)
+	bs := bufio.NewScanner(r)
+	for bs.Scan() {
+		line := strings.TrimSpace(bs.Text())
+		eq := strings.IndexByte(line, '=')
+		if eq == -1 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k := line[:eq]
+		v := strings.Trim(line[eq+1:], `"'`)
+		switch k {
+		case "ID":
+			id = v
+		case "ID_LIKE":
+			like = strings.Fields(v)
+		}
+	}
+	return id, like, bs.Err()
+}
@@ -0,0 +1,190 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"khan.rip/rio"
+)
+
+const (
+	PackageManagerApt    = "apt"
+	PackageManagerDnf    = "dnf"
+	PackageManagerPkgAdd = "pkg_add"
+)
+
+// PackageManager picks the package manager backend for a host.
+func PackageManager(info *rio.Info) (string, error) {
+	if info.OS == "openbsd" {
+		return PackageManagerPkgAdd, nil
+	}
+	if info.OS == "linux" {
+		for _, d := range append([]string{info.Distro}, info.DistroLike...) {
+			switch d {
+			case "debian", "ubuntu":
+				return PackageManagerApt, nil
+			case "rhel", "fedora", "centos":
+				return PackageManagerDnf, nil
+			}
+		}
+		return "", fmt.Errorf("Unsupported Linux distribution %#v for package management", info.Distro)
+	}
+	return "", fmt.Errorf("Unsupported OS %#v for package management", info.OS)
+}
+
+// LoadPackages returns all installed packages with their versions.
+func LoadPackages(ctx context.Context, host rio.Host) (map[string]*rio.Package, error) {
+	info, err := host.Info(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pm, err := PackageManager(info)
+	if err != nil {
+		return nil, err
+	}
+
+	var cmd *rio.Cmd
+	switch pm {
+	case PackageManagerApt:
+		cmd = rio.ReadOnlyCommand(ctx, "dpkg-query", "-W", "-f", `${db:Status-Abbrev}\t${Package}\t${Version}\n`)
+	case PackageManagerDnf:
+		cmd = rio.ReadOnlyCommand(ctx, "rpm", "-qa", "--qf", `ii\t%{NAME}\t%{VERSION}-%{RELEASE}\n`)
+	case PackageManagerPkgAdd:
+		cmd = rio.ReadOnlyCommand(ctx, "pkg_info", "-q")
+	}
+
+	buf := &bytes.Buffer{}
+	cmd.Stdout = buf
+	if err := host.Exec(cmd); err != nil {
+		return nil, err
+	}
+
+	r := map[string]*rio.Package{}
+
+	bs := bufio.NewScanner(buf)
+	for bs.Scan() {
+		line := strings.TrimSpace(bs.Text())
+		if line == "" {
+			continue
+		}
+
+		if pm == PackageManagerPkgAdd {
+			// OpenBSD package names look like "curl-8.1.2p0" or "py3-pip-23.1.2"
+			name, version := SplitPkgName(line)
+			r[name] = &rio.Package{Name: name, Version: version}
+			continue
+		}
+
+		row := strings.Split(line, "\t")
+		if len(row) < 3 {
+			continue
+		}
+		// dpkg keeps rows around for removed packages with config files left behind
+		if !strings.HasPrefix(row[0], "ii") {
+			continue
+		}
+		r[row[1]] = &rio.Package{Name: row[1], Version: row[2]}
+	}
+
+	return r, bs.Err()
+}
+
+// SplitPkgName splits an OpenBSD package name into stem and version. The version
+// starts at the first dash followed by a digit.
+func SplitPkgName(s string) (string, string) {
+	for i := 0; i < len(s)-1; i++ {
+		if s[i] == '-' && s[i+1] >= '0' && s[i+1] <= '9' {
+			return s[:i], s[i+1:]
+		}
+	}
+	return s, ""
+}
+
+// PackageVersionMatches reports whether an installed version satisfies a
+// pinned one. rpm versions are VERSION-RELEASE, and dnf takes a pin of
+// either, so a pin without a dash only has to match VERSION.
+func PackageVersionMatches(pm, want, have string) bool {
+	if want == have {
+		return true
+	}
+	if pm == PackageManagerDnf && !strings.Contains(want, "-") {
+		if dash := strings.LastIndexByte(have, '-'); dash != -1 {
+			return want == have[:dash]
+		}
+	}
+	return false
+}
+
+func InstallPackages(ctx context.Context, host rio.Host, pkgs []*rio.Package) error {
+	if len(pkgs) == 0 {
+		return nil
+	}
+
+	info, err := host.Info(ctx)
+	if err != nil {
+		return err
+	}
+	pm, err := PackageManager(info)
+	if err != nil {
+		return err
+	}
+
+	var args []string
+	for _, p := range pkgs {
+		spec := p.Name
+		if p.Version != "" {
+			switch pm {
+			case PackageManagerApt:
+				spec += "=" + p.Version
+			case PackageManagerDnf, PackageManagerPkgAdd:
+				spec += "-" + p.Version
+			}
+		}
+		args = append(args, spec)
+	}
+
+	switch pm {
+	case PackageManagerApt:
+		// apt-get can't install a specific older version without --allow-downgrades
+		args = append([]string{"DEBIAN_FRONTEND=noninteractive", "apt-get", "install", "-y", "-q", "--allow-downgrades"}, args...)
+		return host.Exec(rio.Command(ctx, "env", args...))
+	case PackageManagerDnf:
+		args = append([]string{"install", "-y", "-q"}, args...)
+		return host.Exec(rio.Command(ctx, "dnf", args...))
+	case PackageManagerPkgAdd:
+		args = append([]string{"-I"}, args...)
+		return host.Exec(rio.Command(ctx, "pkg_add", args...))
+	}
+	return nil
+}
+
+func RemovePackages(ctx context.Context, host rio.Host, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	info, err := host.Info(ctx)
+	if err != nil {
+		return err
+	}
+	pm, err := PackageManager(info)
+	if err != nil {
+		return err
+	}
+
+	switch pm {
+	case PackageManagerApt:
+		args := append([]string{"DEBIAN_FRONTEND=noninteractive", "apt-get", "remove", "-y", "-q"}, names...)
+		return host.Exec(rio.Command(ctx, "env", args...))
+	case PackageManagerDnf:
+		args := append([]string{"remove", "-y", "-q"}, names...)
+		return host.Exec(rio.Command(ctx, "dnf", args...))
+	case PackageManagerPkgAdd:
+		args := append([]string{"-I"}, names...)
+		return host.Exec(rio.Command(ctx, "pkg_delete", args...))
+	}
+	return nil
+}
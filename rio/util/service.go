@@ -1,11 +1,104 @@
 package util
 
 import (
-	"errors"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 
 	"khan.rip/rio"
 )
 
-func LoadServices(host rio.Host) (map[string]*rio.Service, error) {
-	return nil, errors.New("FIXME")
+// systemctl list-unit-files -o json
+type unitFile struct {
+	UnitFile string `json:"unit_file"`
+	State    string `json:"state"`
+}
+
+func LoadServices(ctx context.Context, host rio.Host) (map[string]*rio.Service, error) {
+	info, err := host.Info(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if info.OS != "linux" {
+		return nil, fmt.Errorf("Service management is not supported on OS %#v (systemd only)", info.OS)
+	}
+
+	buf := &bytes.Buffer{}
+	cmd := rio.ReadOnlyCommand(ctx, "systemctl", "list-units", "--all", "--type=service", "--no-pager", "-o", "json")
+	cmd.Stdout = buf
+	if err := host.Exec(cmd); err != nil {
+		return nil, err
+	}
+
+	var units []*rio.Service
+	if err := json.Unmarshal(buf.Bytes(), &units); err != nil {
+		return nil, err
+	}
+
+	r := map[string]*rio.Service{}
+	for _, u := range units {
+		r[u.Unit] = u
+	}
+
+	// Units that are installed but not loaded don't show up in list-units.
+	buf = &bytes.Buffer{}
+	cmd = rio.ReadOnlyCommand(ctx, "systemctl", "list-unit-files", "--type=service", "--no-pager", "-o", "json")
+	cmd.Stdout = buf
+	if err := host.Exec(cmd); err != nil {
+		return nil, err
+	}
+
+	var files []unitFile
+	if err := json.Unmarshal(buf.Bytes(), &files); err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		s, ok := r[f.UnitFile]
+		if !ok {
+			s = &rio.Service{
+				Unit:   f.UnitFile,
+				Active: "inactive",
+				Sub:    "dead",
+			}
+			r[f.UnitFile] = s
+		}
+		s.State = f.State
+	}
+
+	return r, nil
+}
+
+func UpdateService(ctx context.Context, host rio.Host, old *rio.Service, service *rio.Service) error {
+	if old == nil {
+		return fmt.Errorf("Service %#v does not exist", service.Unit)
+	}
+	if old.Enabled() != service.Enabled() {
+		verb := "disable"
+		if service.Enabled() {
+			verb = "enable"
+		}
+		if err := host.Exec(rio.Command(ctx, "systemctl", verb, service.Unit)); err != nil {
+			return err
+		}
+	}
+	if old.Running() != service.Running() {
+		verb := "stop"
+		if service.Running() {
+			verb = "start"
+		}
+		if err := host.Exec(rio.Command(ctx, "systemctl", verb, service.Unit)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func RestartService(ctx context.Context, host rio.Host, unit string) error {
+	return host.Exec(rio.Command(ctx, "systemctl", "restart", unit))
+}
+
+func ReloadService(ctx context.Context, host rio.Host, unit string) error {
+	return host.Exec(rio.Command(ctx, "systemctl", "reload", unit))
 }
@@ -18,6 +18,7 @@ const (
 	S_ifmt     = 0170000 // type of file mask
 	S_ifdir    = 0040000 // directory
 	S_ifreg    = 0100000 // regular
+	S_iflnk    = 0120000 // symbolic link
 	S_justmode = 0777    // this is me ignoring things like suid for now
 )
 
@@ -27,6 +28,7 @@ type FileInfo struct {
 	Fmode    os.FileMode
 	Fmodtime time.Time
 	Fisdir   bool
+	Fislink  bool
 
 	Fuid uint32
 	Fgid uint32
@@ -58,8 +60,8 @@ func (fi *FileInfo) Gid() uint32 {
 }
 
 func (fi *FileInfo) String() string {
-	return fmt.Sprintf("%T name %#v size %d mode %o mtime %v isdir %v uid %d gid %d",
-		fi, fi.Fname, fi.Fsize, fi.Fmode, fi.Fmodtime, fi.Fisdir, fi.Fuid, fi.Fgid)
+	return fmt.Sprintf("%T name %#v size %d mode %o mtime %v isdir %v islink %v uid %d gid %d",
+		fi, fi.Fname, fi.Fsize, fi.Fmode, fi.Fmodtime, fi.Fisdir, fi.Fislink, fi.Fuid, fi.Fgid)
 }
 
 // 10 17547654 drwxr-xr-x 2 joel joel 70100322 512 "Dec 18 19:52:23 2020" "Dec 18 19:52:23 2020" "Dec 18 19:52:23 2020" 32768 8 0 Hi There
@@ -98,6 +100,19 @@ var linuxStatRe = regexp.MustCompile(`^(.*) (\d+) \d+ ([a-fA-F0-9]+) (\d+) (\d+)
 
 // /tmp/file_duck 9 8 81a4 1000 1000 2d 20963 1 0 0 1608356438 1608356438 1608356438 0 4096
 
+// StatCommand is the command line prefix for stat output ParseStat
+// understands. follow is for Stat, as opposed to Lstat.
+func StatCommand(osname string, follow bool) string {
+	statcmd := "stat -t"
+	if osname == "openbsd" {
+		statcmd = "stat -r"
+	}
+	if follow {
+		statcmd += " -L"
+	}
+	return statcmd
+}
+
 func ParseStat(osname, fpath, stdout, stderr string, execerr error) (*FileInfo, error) {
 	if execerr != nil {
 		if strings.HasPrefix(stderr, "stat: ") && strings.HasSuffix(stderr, "No such file or directory") {
@@ -149,6 +164,7 @@ func ParseStat(osname, fpath, stdout, stderr string, execerr error) (*FileInfo,
 		if fi.Fmode&S_ifmt == S_ifdir {
 			fi.Fisdir = true
 		}
+		fi.Fislink = fi.Fmode&S_ifmt == S_iflnk
 		return fi, nil
 
 	case "linux":
@@ -184,6 +200,7 @@ func ParseStat(osname, fpath, stdout, stderr string, execerr error) (*FileInfo,
 		if fi.Fmode&S_ifmt == S_ifdir {
 			fi.Fisdir = true
 		}
+		fi.Fislink = fi.Fmode&S_ifmt == S_iflnk
 		return fi, nil
 
 	default:
@@ -206,6 +223,7 @@ func ConvertStat(f os.FileInfo) (*FileInfo, error) {
 		Fmode:    f.Mode(),
 		Fmodtime: f.ModTime(),
 		Fisdir:   f.IsDir(),
+		Fislink:  f.Mode()&os.ModeSymlink != 0,
 	}
 
 	switch st := sys.(type) {
@@ -0,0 +1,38 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"khan.rip/rio"
+)
+
+func Symlink(ctx context.Context, host rio.Host, target, fpath string) error {
+	return host.Exec(rio.Command(ctx, "ln", "-s", target, fpath))
+}
+
+func Readlink(ctx context.Context, host rio.Host, fpath string) (string, error) {
+	buf := &bytes.Buffer{}
+	cmd := rio.ReadOnlyCommand(ctx, "readlink", fpath)
+	cmd.Stdout = buf
+	if err := host.Exec(cmd); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// Lchown changes the ownership of a symlink itself, not what it points at
+func Lchown(ctx context.Context, host rio.Host, fpath string, uid uint32, gid uint32) error {
+	return host.Exec(rio.Command(ctx, "chown", "-h", fmt.Sprintf("%d:%d", uid, gid), fpath))
+}
+
+// IsLink reports whether fi, from Lstat, is a symlink
+func IsLink(fi os.FileInfo) bool {
+	if ufi, err := ConvertStat(fi); err == nil {
+		return ufi.Fislink
+	}
+	return fi.Mode()&os.ModeSymlink != 0
+}
@@ -3,14 +3,16 @@ package util
 import (
 	"bytes"
 	"context"
+	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 
 	"khan.rip/rio"
 )
 
-func LoadPasswords(host rio.Host) (map[string]*rio.Password, error) {
-	info, err := host.Info()
+func LoadPasswords(ctx context.Context, host rio.Host) (map[string]*rio.Password, error) {
+	info, err := host.Info(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -20,7 +22,7 @@ func LoadPasswords(host rio.Host) (map[string]*rio.Password, error) {
 		shadowfile = "/etc/master.passwd"
 	}
 
-	fh, err := host.Open(shadowfile)
+	fh, err := host.Open(ctx, shadowfile)
 	if err != nil {
 		return nil, err
 	}
@@ -37,18 +39,38 @@ func LoadPasswords(host rio.Host) (map[string]*rio.Password, error) {
 		if len(row) < 8 {
 			continue
 		}
-		r[row[0]] = &rio.Password{
-			Name:  row[0],
-			Crypt: row[1],
+		p := rio.NoAging(row[0], row[1])
+		if info.OS == "openbsd" {
+			// name:password:uid:gid:class:change:expire:..., in seconds
+			if secs := shadowField(row[6]); secs > 0 {
+				p.Expire = secs / 86400
+			}
+		} else {
+			// name:password:lastchg:min:max:warn:inactive:expire:
+			p.LastChange = shadowField(row[2])
+			p.MinDays = shadowField(row[3])
+			p.MaxDays = shadowField(row[4])
+			p.WarnDays = shadowField(row[5])
+			p.InactiveDays = shadowField(row[6])
+			p.Expire = shadowField(row[7])
 		}
-		// TODO fancy /etc/shadow fields for expiration and stuff
+		r[row[0]] = p
 	}
 
 	return r, nil
 }
 
-func LoadUserGroups(host rio.Host) (map[string]*rio.User, map[string]*rio.Group, error) {
-	//info, err := host.Info()
+// shadowField parses a number from the shadow file. Empty is -1.
+func shadowField(s string) int {
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return -1
+	}
+	return v
+}
+
+func LoadUserGroups(ctx context.Context, host rio.Host) (map[string]*rio.User, map[string]*rio.Group, error) {
+	//info, err := host.Info(ctx)
 	//if err != nil {
 	//	return nil, nil, err
 	//}
@@ -59,7 +81,7 @@ func LoadUserGroups(host rio.Host) (map[string]*rio.User, map[string]*rio.Group,
 	userGids := map[string]uint32{}
 	gids := map[uint32]string{}
 
-	fh, err := host.Open("/etc/passwd")
+	fh, err := host.Open(ctx, "/etc/passwd")
 	if err != nil {
 		return nil, nil, err
 	}
@@ -104,7 +126,7 @@ func LoadUserGroups(host rio.Host) (map[string]*rio.User, map[string]*rio.Group,
 		return nil, nil, err
 	}
 
-	gfh, err := host.Open("/etc/group")
+	gfh, err := host.Open(ctx, "/etc/group")
 	if err != nil {
 		return nil, nil, err
 	}
@@ -136,6 +158,10 @@ func LoadUserGroups(host rio.Host) (map[string]*rio.User, map[string]*rio.Group,
 		gids[g.Gid] = g.Name
 		for _, u := range strings.Split(row[3], ",") {
 			u = strings.TrimSpace(u)
+			if u == "" {
+				continue
+			}
+			g.Members = append(g.Members, u)
 			uu, ok := users[u]
 			if ok {
 				uu.Groups = append(uu.Groups, g.Name)
@@ -154,8 +180,35 @@ func LoadUserGroups(host rio.Host) (map[string]*rio.User, map[string]*rio.Group,
 	return users, groups, nil
 }
 
-func CreateGroup(host rio.Host, group *rio.Group) (uint32, error) {
-	ctx := context.Background()
+// SortedUsers lists a users map, skipping dry run tombstones
+func SortedUsers(users map[string]*rio.User) []*rio.User {
+	var r []*rio.User
+	for _, u := range users {
+		if u != nil {
+			r = append(r, u)
+		}
+	}
+	sort.Slice(r, func(a, b int) bool {
+		return r[a].Name < r[b].Name
+	})
+	return r
+}
+
+// SortedGroups lists a groups map, skipping dry run tombstones
+func SortedGroups(groups map[string]*rio.Group) []*rio.Group {
+	var r []*rio.Group
+	for _, g := range groups {
+		if g != nil {
+			r = append(r, g)
+		}
+	}
+	sort.Slice(r, func(a, b int) bool {
+		return r[a].Name < r[b].Name
+	})
+	return r
+}
+
+func CreateGroup(ctx context.Context, host rio.Host, group *rio.Group) (uint32, error) {
 	var ops []string
 
 	if group.Name != "root" && group.Gid == 0 {
@@ -185,36 +238,103 @@ func CreateGroup(host rio.Host, group *rio.Group) (uint32, error) {
 			return 0, err
 		}
 
-		// Return the newly created gid
-		return uint32(v), nil
+		gid := uint32(v)
+		return gid, createMembers(ctx, host, group, gid)
 	}
 
 	// Assume we created the requested gid
-	return group.Gid, nil
+	return group.Gid, createMembers(ctx, host, group, group.Gid)
 }
 
-func UpdateGroup(host rio.Host, old *rio.Group, group *rio.Group) error {
-	ctx := context.Background()
+// createMembers puts the members of a group we just made in it
+func createMembers(ctx context.Context, host rio.Host, group *rio.Group, gid uint32) error {
+	if len(group.Members) == 0 {
+		return nil
+	}
+	return UpdateGroup(ctx, host, &rio.Group{Name: group.Name, Gid: gid}, group)
+}
+
+func UpdateGroup(ctx context.Context, host rio.Host, old *rio.Group, group *rio.Group) error {
 	if old.Gid != group.Gid {
 		if err := host.Exec(rio.Command(ctx, "groupmod", "-g", strconv.FormatUint(uint64(group.Gid), 10), group.Name)); err != nil {
 			return err
 		}
 	}
+	if SameNames(old.Members, group.Members) {
+		return nil
+	}
+
+	info, err := host.Info(ctx)
+	if err != nil {
+		return err
+	}
+	if info.OS != "openbsd" {
+		return host.Exec(rio.Command(ctx, "gpasswd", "-M", strings.Join(group.Members, ","), group.Name))
+	}
+
+	// No gpasswd: usermod -G adds a user to a group, but taking one out
+	// means rewriting all of that user's groups.
+	added, removed := DiffNames(old.Members, group.Members)
+	if len(removed) > 0 {
+		return fmt.Errorf("Cannot remove %s from group %#v: Not supported on OpenBSD", strings.Join(removed, ", "), group.Name)
+	}
+	for _, name := range added {
+		if err := host.Exec(rio.Command(ctx, "usermod", "-G", group.Name, name)); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func DeleteGroup(host rio.Host, name string) error {
-	ctx := context.Background()
+// SameNames compares lists of user or group names, in any order
+func SameNames(a, b []string) bool {
+	added, removed := DiffNames(a, b)
+	return len(added) == 0 && len(removed) == 0
+}
+
+// DiffNames returns the names in b but not a, and in a but not b
+func DiffNames(a, b []string) (added, removed []string) {
+	ina := map[string]bool{}
+	for _, name := range a {
+		ina[name] = true
+	}
+	inb := map[string]bool{}
+	for _, name := range b {
+		inb[name] = true
+		if !ina[name] {
+			added = append(added, name)
+		}
+	}
+	for _, name := range a {
+		if !inb[name] {
+			removed = append(removed, name)
+		}
+	}
+	return added, removed
+}
+
+func DeleteGroup(ctx context.Context, host rio.Host, name string) error {
 	if err := host.Exec(rio.Command(ctx, "groupdel", name)); err != nil {
 		return err
 	}
 	return nil
 }
 
-func CreateUser(host rio.Host, user *rio.User) (uint32, error) {
-	ctx := context.Background()
+func CreateUser(ctx context.Context, host rio.Host, user *rio.User, createHome bool) (uint32, error) {
+	info, err := host.Info(ctx)
+	if err != nil {
+		return 0, err
+	}
+
 	var ops []string
 
+	if createHome {
+		ops = append(ops, "-m")
+	} else if info.OS == "linux" {
+		// CREATE_HOME in login.defs (RHEL, Fedora) makes one unless told not to
+		ops = append(ops, "-M")
+	}
+
 	if user.Name != "root" && user.Uid == 0 {
 		// auto-assign uid.
 	} else {
@@ -266,8 +386,7 @@ func CreateUser(host rio.Host, user *rio.User) (uint32, error) {
 	return user.Uid, nil
 }
 
-func UpdateUser(host rio.Host, old *rio.User, user *rio.User) error {
-	ctx := context.Background()
+func UpdateUser(ctx context.Context, host rio.Host, old *rio.User, user *rio.User, moveHome bool) error {
 	var ops []string
 
 	if old.Uid != user.Uid {
@@ -275,6 +394,9 @@ func UpdateUser(host rio.Host, old *rio.User, user *rio.User) error {
 	}
 	if old.Home != user.Home {
 		ops = append(ops, "-d", user.Home)
+		if moveHome {
+			ops = append(ops, "-m")
+		}
 	}
 	if old.Group != user.Group {
 		ops = append(ops, "-g", user.Group)
@@ -295,23 +417,140 @@ func UpdateUser(host rio.Host, old *rio.User, user *rio.User) error {
 	if err := host.Exec(rio.Command(ctx, "usermod", ops...)); err != nil {
 		return err
 	}
+	if old.Uid != user.Uid {
+		info, err := host.Info(ctx)
+		if err != nil {
+			return err
+		}
+		if info.OS == "openbsd" {
+			return ChownHome(ctx, host, user.Home, old.Uid, user.Uid)
+		}
+	}
 	return nil
 }
 
-func DeleteUser(host rio.Host, name string) error {
-	ctx := context.Background()
-	if err := host.Exec(rio.Command(ctx, "userdel", name)); err != nil {
+// ChownHome gives the files in home owned by olduid to newuid. Linux's
+// usermod -u does this itself, OpenBSD's doesn't. A home that isn't the
+// user's (like "/" for some daemons) is left alone.
+func ChownHome(ctx context.Context, host rio.Host, home string, olduid, newuid uint32) error {
+	if home == "" || home == "/" {
+		return nil
+	}
+	fi, err := host.Stat(ctx, home)
+	if err != nil {
+		if IsErrNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	ufi, err := ConvertStat(fi)
+	if err != nil {
+		return err
+	}
+	if !ufi.Fisdir || (ufi.Fuid != olduid && ufi.Fuid != newuid) {
+		return nil
+	}
+	return host.Exec(rio.Command(ctx, "find", home, "-xdev", "-user", strconv.FormatUint(uint64(olduid), 10),
+		"-exec", "chown", "-h", strconv.FormatUint(uint64(newuid), 10), "{}", "+"))
+}
+
+// CreateHome makes home for a user that already exists, like useradd -m
+// would have: a copy of /etc/skel, theirs, mode 0700
+func CreateHome(ctx context.Context, host rio.Host, home string, uid, gid uint32) error {
+	if err := host.MkdirAll(ctx, home); err != nil {
+		return err
+	}
+	if _, err := host.Stat(ctx, "/etc/skel"); err == nil {
+		if err := host.Exec(rio.Command(ctx, "cp", "-pR", "/etc/skel/.", home)); err != nil {
+			return err
+		}
+		if err := host.Exec(rio.Command(ctx, "chown", "-R", fmt.Sprintf("%d:%d", uid, gid), home)); err != nil {
+			return err
+		}
+	} else if !IsErrNotFound(err) {
+		return err
+	}
+	if err := host.Chown(ctx, home, uid, gid); err != nil {
+		return err
+	}
+	return host.Chmod(ctx, home, 0700)
+}
+
+func DeleteUser(ctx context.Context, host rio.Host, name string, removeHome bool) error {
+	ops := []string{name}
+	if removeHome {
+		ops = []string{"-r", name}
+	}
+	if err := host.Exec(rio.Command(ctx, "userdel", ops...)); err != nil {
 		return err
 	}
 	return nil
 }
 
-func UpdatePassword(host rio.Host, old *rio.Password, password *rio.Password) error {
-	ctx := context.Background()
+// VerifyCrypt checks plain against crypt with the host's own crypt(3), for
+// schemes (like yescrypt) khan can't check itself. Both go over stdin so
+// neither shows up in ps. Without perl, or on any error, it doesn't match.
+func VerifyCrypt(ctx context.Context, host rio.Host, plain, crypt string) bool {
+	if crypt == "" || strings.ContainsAny(plain+crypt, "\n") {
+		return false
+	}
+	cmd := rio.ReadOnlyCommand(ctx, "perl", "-e", `chomp(my $p = <STDIN>); chomp(my $c = <STDIN>); exit(crypt($p, $c) eq $c ? 0 : 1)`)
+	cmd.Stdin = strings.NewReader(plain + "\n" + crypt + "\n")
+	return host.Exec(cmd) == nil
+}
+
+func UpdatePassword(ctx context.Context, host rio.Host, old *rio.Password, password *rio.Password) error {
 	if old == nil || old.Crypt != password.Crypt {
 		if err := host.Exec(rio.Command(ctx, "usermod", "-p", password.Crypt, password.Name)); err != nil {
 			return err
 		}
 	}
-	return nil
+	if old == nil {
+		// Nothing to compare aging with
+		return nil
+	}
+
+	info, err := host.Info(ctx)
+	if err != nil {
+		return err
+	}
+	if info.OS == "openbsd" {
+		if old.MinDays != password.MinDays || old.MaxDays != password.MaxDays ||
+			old.WarnDays != password.WarnDays || old.InactiveDays != password.InactiveDays {
+			return fmt.Errorf("Password aging for %#v: OpenBSD only has account expiry", password.Name)
+		}
+		if old.Expire != password.Expire {
+			// 0 is never there, so day 0 is its first second
+			secs := 0
+			if password.Expire > 0 {
+				secs = password.Expire * 86400
+			} else if password.Expire == 0 {
+				secs = 1
+			}
+			return host.Exec(rio.Command(ctx, "usermod", "-e", strconv.Itoa(secs), password.Name))
+		}
+		return nil
+	}
+
+	var ops []string
+	if old.MinDays != password.MinDays {
+		ops = append(ops, "-m", strconv.Itoa(password.MinDays))
+	}
+	if old.MaxDays != password.MaxDays {
+		ops = append(ops, "-M", strconv.Itoa(password.MaxDays))
+	}
+	if old.WarnDays != password.WarnDays {
+		ops = append(ops, "-W", strconv.Itoa(password.WarnDays))
+	}
+	if old.InactiveDays != password.InactiveDays {
+		ops = append(ops, "-I", strconv.Itoa(password.InactiveDays))
+	}
+	if old.Expire != password.Expire {
+		ops = append(ops, "-E", strconv.Itoa(password.Expire))
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	ops = append(ops, password.Name)
+	return host.Exec(rio.Command(ctx, "chage", ops...))
 }
@@ -1,6 +1,7 @@
 package khan
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -10,13 +11,12 @@ import (
 	"sync"
 	"time"
 
-	"github.com/desops/sshpool"
-
 	"github.com/flosch/pongo2/v4"
 )
 
 var (
 	errNeededItemFailed = errors.New("Needed item failed")
+	errRunCanceled      = errors.New("Run canceled")
 )
 
 // Run is the context for an execution run, on one or more servers.
@@ -25,10 +25,26 @@ type Run struct {
 	Diff    bool
 	Verbose bool
 
-	Pool  *sshpool.Pool
+	// Strict makes unresolved After dependencies an error instead of a warning
+	Strict bool
+
+	// SSH host key checking mode (see remote.HostKeyCallback) and known_hosts file
+	HostKeyCheck string
+	KnownHosts   string
+
 	Hosts []*Host
 
-	assetfn func(string) (io.ReadCloser, error)
+	ssh *sshenv
+
+	// agentenv is what agentEnv found, for each agent's child
+	agentenv map[string]string
+
+	// ctx is canceled on SIGINT. Items that haven't started yet are skipped,
+	// and items in flight see it through Apply.
+	ctx context.Context
+
+	assetfn    func(string) (io.ReadCloser, error)
+	assetnames func() []string
 
 	sourceprefix string
 	describe     string
@@ -50,6 +66,10 @@ type Run struct {
 	nextid           int
 	fences           map[string]*sync.Mutex
 	befores          map[string][]string
+	providers        map[string]Item
+	notifies         map[string]*notify
+	notifying        map[int][]*notify
+	facts            map[*Host]map[string]string
 	errors           map[string]error
 	itemsuccesscount int
 	itemstatuscount  map[Status]int
@@ -71,8 +91,12 @@ type imeta struct {
 	host   *Host
 }
 
+func (im *imeta) describe(run *Run) string {
+	return fmt.Sprintf("%s %s on %s", strings.TrimPrefix(im.source, run.sourceprefix+"/"), im.item, im.host.Name)
+}
+
 func (im *imeta) WrapError(run *Run, err error) error {
-	return fmt.Errorf("%s %s on %s: %w", strings.TrimPrefix(im.source, run.sourceprefix+"/"), im.item, im.host.Name, err)
+	return fmt.Errorf("%s: %w", im.describe(run), err)
 }
 
 // Add will clone items for each configured host and add them to the run graph
@@ -102,6 +126,13 @@ func (r *Run) AddFromSource(source string, add ...Item) error {
 			return fmt.Errorf("Item already added: %v", item)
 		}
 		for _, host := range r.Hosts {
+			ok, err := r.targets(item, host)
+			if err != nil {
+				return fmt.Errorf("%s %s: %w", strings.TrimPrefix(source, r.sourceprefix+"/"), item, err)
+			}
+			if !ok {
+				continue
+			}
 			c := item.Clone()
 			if err := r.addHostItem(host, source, c); err != nil {
 				return err
@@ -112,6 +143,27 @@ func (r *Run) AddFromSource(source string, add ...Item) error {
 	return nil
 }
 
+// addInner registers an item that parent applies itself, like the Files of
+// a Tree, so its output says which host it's on. It isn't scheduled.
+func (r *Run) addInner(host *Host, parent Item, item Item) Item {
+	r.itemsmu.Lock()
+	defer r.itemsmu.Unlock()
+
+	source := ""
+	if im := r.meta[parent.ID()]; im != nil {
+		source = im.source
+	}
+
+	r.nextid++
+	item.SetID(r.nextid)
+	r.meta[r.nextid] = &imeta{
+		source: source,
+		host:   host,
+		item:   item,
+	}
+	return item
+}
+
 // always have itemsmu locked before calling this
 func (r *Run) addHostItem(host *Host, source string, item Item) error {
 	if item.ID() != 0 {
@@ -140,6 +192,7 @@ func (r *Run) addHostItem(host *Host, source string, item Item) error {
 		}
 		r.fences[p] = &sync.Mutex{}
 		r.fences[p].Lock()
+		r.providers[p] = item
 
 		for _, bef := range item.Before() {
 			bef = host.Key() + "-" + bef
@@ -147,13 +200,19 @@ func (r *Run) addHostItem(host *Host, source string, item Item) error {
 		}
 	}
 
+	if notifier, ok := item.(Notifier); ok {
+		if err := r.addNotifies(host, source, item, notifier); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 func (r *Run) runinit() error {
 	// Do some initialization for items queued up at init() time.
 	// Now that we have a proper host list, we can clone the items
-	// for each host.
+	// for each host they target.
 	r.itemsmu.Lock()
 	defer r.itemsmu.Unlock()
 
@@ -164,6 +223,13 @@ func (r *Run) runinit() error {
 			return iitem.WrapError(r, fmt.Errorf("Item already added"))
 		}
 		for _, host := range r.Hosts {
+			ok, err := r.targets(iitem.item, host)
+			if err != nil {
+				return iitem.WrapError(r, err)
+			}
+			if !ok {
+				continue
+			}
 			c := iitem.item.Clone()
 			if err := r.addHostItem(host, iitem.source, c); err != nil {
 				return err
@@ -173,15 +239,23 @@ func (r *Run) runinit() error {
 
 	r.inititems = nil
 
-	return nil
+	return r.validateGraph()
 }
 
-func (r *Run) run() error {
-	if err := r.runinit(); err != nil {
-		return err
+// itemhost returns the name of the host an item was cloned for
+func (r *Run) itemhost(item Item) string {
+	r.itemsmu.Lock()
+	defer r.itemsmu.Unlock()
+	if im, ok := r.meta[item.ID()]; ok {
+		return im.host.Name
 	}
+	return ""
+}
 
-	r.out = &outputter{}
+func (r *Run) run() error {
+	if r.out == nil {
+		r.out = &outputter{}
+	}
 
 	errs := make(chan error)
 
@@ -198,6 +272,7 @@ func (r *Run) run() error {
 		errors             int
 		interesting_errors []error
 		skipfailures       int
+		canceled           int
 	)
 
 	for {
@@ -224,6 +299,10 @@ func (r *Run) run() error {
 				status, err := func() (Status, error) {
 					// be a little tricky here to allow fences to appear in the future
 					for {
+						if r.ctx.Err() != nil {
+							return 0, errRunCanceled
+						}
+
 						var (
 							mu      *sync.Mutex
 							waiting string
@@ -231,6 +310,16 @@ func (r *Run) run() error {
 						r.itemsmu.Lock()
 						waitlist := make([]string, 0, len(item.After()))
 						for _, after := range item.After() {
+							if strings.HasSuffix(after, "*") {
+								// every provider of a key with this prefix
+								prefix := host.Key() + "-" + strings.TrimSuffix(after, "*")
+								for n, p := range r.providers {
+									if _, ok := r.fences[n]; ok && p != item && strings.HasPrefix(n, prefix) {
+										waitlist = append(waitlist, n)
+									}
+								}
+								continue
+							}
 							waitlist = append(waitlist, host.Key()+"-"+after)
 						}
 						for _, pr := range item.Provides() {
@@ -267,12 +356,19 @@ func (r *Run) run() error {
 							return 0, fmt.Errorf("Parent task error status not found")
 						}
 						if parenterr != nil {
+							if r.ctx.Err() != nil {
+								return 0, errRunCanceled
+							}
 							return 0, errNeededItemFailed
 						}
 					}
 
+					if r.ctx.Err() != nil {
+						return 0, errRunCanceled
+					}
+
 					start := time.Now()
-					status, err := item.Apply(host)
+					status, err := item.Apply(r.ctx, host)
 					if err != nil {
 						err = ex.im.WrapError(r, err)
 					}
@@ -291,6 +387,9 @@ func (r *Run) run() error {
 						delete(r.fences, p)
 					}
 				}
+				for _, n := range r.notifying[item.ID()] {
+					n.finish(status, err)
+				}
 				if err == nil {
 					r.itemsuccesscount++
 				}
@@ -307,9 +406,15 @@ func (r *Run) run() error {
 			//if r.Dry {
 			//	fmt.Fprintln(os.Stderr, "No actions actually performed (dry run)")
 			//}
-			if errors == 0 && skipfailures == 0 {
+			if errors == 0 && skipfailures == 0 && canceled == 0 {
 				return nil
 			}
+			if canceled > 0 {
+				fmt.Fprintf(os.Stderr, "%s─── interrupted: %d items not run ───%s\n", color(Yellow), canceled, reset())
+				if errors == 0 && skipfailures == 0 {
+					return fmt.Errorf("Interrupted (%d items not run)", canceled)
+				}
+			}
 			fmt.Fprintf(os.Stderr, "%s─── %d failures ───%s\n", color(Red), errors, reset())
 			for _, err := range interesting_errors {
 				fmt.Fprintln(os.Stderr, err)
@@ -326,7 +431,9 @@ func (r *Run) run() error {
 		running--
 
 		if err != nil {
-			if err == errNeededItemFailed {
+			if err == errRunCanceled {
+				canceled++
+			} else if err == errNeededItemFailed {
 				skipfailures++
 			} else {
 				interesting_errors = append(interesting_errors, err)
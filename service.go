@@ -1,7 +1,10 @@
 package khan
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"strings"
 )
 
 type Service struct {
@@ -10,6 +13,12 @@ type Service struct {
 	Running bool
 	Enabled bool
 
+	// Requires lists extra keys to order after, e.g. "package:nginx"
+	Requires []string
+
+	// Limits which hosts get this item
+	Target
+
 	id int
 }
 
@@ -41,7 +50,7 @@ func (s *Service) StaticFiles() []string {
 }
 
 func (s *Service) After() []string {
-	return nil
+	return s.Requires
 }
 func (s *Service) Before() []string {
 	return nil
@@ -50,6 +59,84 @@ func (s *Service) Provides() []string {
 	return []string{"service:" + s.Name}
 }
 
-func (s *Service) Apply(host *Host) (Status, error) {
-	return Created, nil
+// unit returns the systemd unit name. A bare name like "nginx" means "nginx.service".
+func (s *Service) unit() string {
+	if strings.IndexByte(s.Name, '.') > -1 {
+		return s.Name
+	}
+	return s.Name + ".service"
+}
+
+func (s *Service) Apply(ctx context.Context, host *Host) (Status, error) {
+	unit := s.unit()
+
+	old, err := host.rh.Service(ctx, unit)
+	if err != nil {
+		return 0, err
+	}
+	if old == nil {
+		return 0, fmt.Errorf("Unknown service %#v", unit)
+	}
+
+	v := *old
+
+	if s.Running {
+		v.Active = "active"
+	} else {
+		v.Active = "inactive"
+	}
+
+	// Only touch units that can actually be toggled. Static, masked, generated
+	// etc. units keep whatever state systemd says they're in.
+	if old.State == "enabled" || old.State == "disabled" {
+		if s.Enabled {
+			v.State = "enabled"
+		} else {
+			v.State = "disabled"
+		}
+	} else if s.Enabled && !old.Enabled() {
+		return 0, fmt.Errorf("Cannot enable %s unit %#v", old.State, unit)
+	}
+
+	if old.Running() == v.Running() && old.Enabled() == v.Enabled() {
+		return Unchanged, nil
+	}
+
+	host.Run.out.Active(host.Run, s, Modified)
+
+	if err := host.rh.UpdateService(ctx, &v); err != nil {
+		return 0, err
+	}
+	return Modified, nil
+}
+
+// Handle restarts or reloads the service when notified. Restart is the default
+// trigger and wins if both were requested. Services that are supposed to be
+// stopped are left alone.
+func (s *Service) Handle(ctx context.Context, host *Host, triggers []string) (Status, error) {
+	if !s.Running {
+		return Unchanged, nil
+	}
+
+	restart := false
+	for _, t := range triggers {
+		switch t {
+		case "", "restart":
+			restart = true
+		case "reload":
+		default:
+			return 0, fmt.Errorf("Unknown service trigger %#v", t)
+		}
+	}
+
+	if restart {
+		if err := host.rh.RestartService(ctx, s.unit()); err != nil {
+			return 0, err
+		}
+	} else {
+		if err := host.rh.ReloadService(ctx, s.unit()); err != nil {
+			return 0, err
+		}
+	}
+	return Modified, nil
 }
@@ -0,0 +1,250 @@
+package khan
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+
+	"khan.rip/rio/remote"
+
+	"github.com/desops/sshpool"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// sshenv is the SSH state shared by all remote hosts in a run. Each host gets
+// its own pool, since user, keys, host key algorithms and jump hosts all come
+// from ~/.ssh/config per host.
+type sshenv struct {
+	verbose  bool
+	hostkeys *remote.HostKeys
+	agent    agent.Agent // nil without SSH_AUTH_SOCK
+
+	signers map[string]ssh.Signer // identity files by path; nil if unusable
+
+	jumpclients map[string]*ssh.Client // by hop chain
+	jumps       []*remote.Jump
+	pools       []*sshpool.Pool
+}
+
+func newsshenv(r *Run) (*sshenv, error) {
+	hostkeys, err := remote.NewHostKeys(r.HostKeyCheck, r.KnownHosts)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &sshenv{
+		verbose:     r.Verbose,
+		hostkeys:    hostkeys,
+		signers:     map[string]ssh.Signer{},
+		jumpclients: map[string]*ssh.Client{},
+	}
+
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			Warnf("Failed to open SSH_AUTH_SOCK, falling back to identity files: %v", err)
+		} else {
+			e.agent = agent.NewClient(conn)
+		}
+	}
+
+	return e, nil
+}
+
+// signer loads an identity file. Missing files are skipped quietly when they
+// are only OpenSSH defaults. There's no passphrase prompt: encrypted keys
+// need to be in the agent.
+//
+// Not safe for concurrent use; hosts are set up one at a time.
+func (e *sshenv) signer(path string, configured bool) ssh.Signer {
+	if s, ok := e.signers[path]; ok {
+		return s
+	}
+	e.signers[path] = nil
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		if configured || !os.IsNotExist(err) {
+			Warnf("Identity file %s: %v", path, err)
+		}
+		return nil
+	}
+	s, err := ssh.ParsePrivateKey(buf)
+	if err != nil {
+		var pperr *ssh.PassphraseMissingError
+		if errors.As(err, &pperr) {
+			if e.agent == nil {
+				Warnf("Identity file %s is encrypted: add it to ssh-agent to use it", path)
+			}
+		} else {
+			Warnf("Identity file %s: %v", path, err)
+		}
+		return nil
+	}
+	e.signers[path] = s
+	return s
+}
+
+func (e *sshenv) config(d *remote.Destination) (*ssh.ClientConfig, error) {
+	var files []ssh.Signer
+	if len(d.IdentityFiles) > 0 {
+		for _, f := range d.IdentityFiles {
+			if s := e.signer(f, true); s != nil {
+				files = append(files, s)
+			}
+		}
+	} else {
+		for _, f := range remote.DefaultIdentityFiles() {
+			if s := e.signer(f, false); s != nil {
+				files = append(files, s)
+			}
+		}
+	}
+
+	if e.agent == nil && len(files) == 0 {
+		return nil, fmt.Errorf("No SSH agent (SSH_AUTH_SOCK) and no usable identity files for %s", d.Alias)
+	}
+
+	// Agent keys first, then identity files the agent doesn't have
+	signers := func() ([]ssh.Signer, error) {
+		var r []ssh.Signer
+		seen := map[string]bool{}
+		if e.agent != nil {
+			as, err := e.agent.Signers()
+			if err != nil && len(files) == 0 {
+				return nil, err
+			}
+			for _, s := range as {
+				seen[string(s.PublicKey().Marshal())] = true
+				r = append(r, s)
+			}
+		}
+		for _, s := range files {
+			if !seen[string(s.PublicKey().Marshal())] {
+				r = append(r, s)
+			}
+		}
+		return r, nil
+	}
+
+	return &ssh.ClientConfig{
+		User: d.User,
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeysCallback(signers),
+		},
+		HostKeyCallback:   e.hostkeys.CallbackFor(d.Addr()),
+		HostKeyAlgorithms: e.hostkeys.Algorithms(d.Addr()),
+		BannerCallback:    ssh.BannerDisplayStderr(),
+	}, nil
+}
+
+// hops resolves a ProxyJump chain. Like OpenSSH, only the first hop's own
+// ProxyJump is followed.
+func (e *sshenv) hops(d *remote.Destination, depth int) ([]remote.JumpHop, error) {
+	if depth > 8 {
+		return nil, fmt.Errorf("ProxyJump for %s nests too deep", d.Alias)
+	}
+	var r []remote.JumpHop
+	for i, hop := range d.ProxyJump {
+		hd, err := remote.ResolveSSHConfig(hop)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 && len(hd.ProxyJump) > 0 {
+			outer, err := e.hops(hd, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			r = append(r, outer...)
+		}
+		config, err := e.config(hd)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, remote.JumpHop{Addr: hd.Addr(), Config: config})
+	}
+	return r, nil
+}
+
+// pool sets up an SSH pool for connect (an ssh config alias or
+// [user@]host[:port]). It returns the pool, the connect string to use with
+// it, and the resolved destination.
+func (e *sshenv) pool(connect string) (*sshpool.Pool, string, *remote.Destination, error) {
+	d, err := remote.ResolveSSHConfig(connect)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	config, err := e.config(d)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	poolconnect := d.Connect()
+
+	if len(d.ProxyJump) > 0 {
+		hops, err := e.hops(d, 0)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		var chain []string
+		for _, hop := range hops {
+			chain = append(chain, hop.Config.User+"@"+hop.Addr)
+		}
+		key := strings.Join(chain, ",")
+
+		client, ok := e.jumpclients[key]
+		if !ok {
+			if e.verbose {
+				fmt.Printf("ssh jump %s\n", key)
+			}
+			client, err = remote.DialJump(hops)
+			if err != nil {
+				return nil, "", nil, fmt.Errorf("%s: %w", d.Alias, err)
+			}
+			e.jumpclients[key] = client
+		}
+
+		jump, err := remote.NewJump(client, d.Addr())
+		if err != nil {
+			return nil, "", nil, err
+		}
+		e.jumps = append(e.jumps, jump)
+		poolconnect = d.User + "@" + jump.Addr()
+	}
+
+	maxconns := 10
+	if len(d.ProxyJump) > 0 {
+		maxconns = 1
+	}
+	pool := sshpool.New(config, &sshpool.PoolConfig{MaxConnections: maxconns, MaxSessions: 10, Debug: e.verbose})
+	e.pools = append(e.pools, pool)
+
+	if len(d.ProxyJump) > 0 {
+		// The jump takes a single connection, so make it before anyone else can
+		session, err := pool.Get(poolconnect)
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("%s: %w", d.Alias, err)
+		}
+		session.Close()
+		session.Put()
+	}
+
+	return pool, poolconnect, d, nil
+}
+
+func (e *sshenv) close() {
+	for _, pool := range e.pools {
+		pool.Close()
+	}
+	for _, jump := range e.jumps {
+		jump.Close()
+	}
+	for _, client := range e.jumpclients {
+		client.Close()
+	}
+}
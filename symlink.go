@@ -0,0 +1,212 @@
+package khan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"khan.rip/rio/util"
+)
+
+// Symlink makes Path a symbolic link to To
+type Symlink struct {
+	Path string `khan:"path,shortkey"`
+
+	// To is what the link points at. It's used as is, so a relative To is
+	// relative to the directory the link is in.
+	To string `khan:"to,shortvalue"`
+
+	// User and Group own the link itself. Left blank, ownership isn't managed.
+	User  string
+	Group string
+
+	// Force replaces a file that's in the way. Without it, anything but a
+	// symlink at Path is an error. Directories are never replaced.
+	Force bool
+
+	Delete bool
+
+	// Requires lists extra keys to order after, e.g. "package:nginx"
+	Requires []string
+
+	// Notify lists handlers to trigger when this item changes, e.g. "service:nginx#reload"
+	Notify []string
+
+	// Limits which hosts get this item
+	Target
+
+	id int
+}
+
+func (s *Symlink) String() string {
+	return s.Path
+}
+
+func (s *Symlink) SetID(id int) {
+	s.id = id
+}
+func (s *Symlink) ID() int {
+	return s.id
+}
+func (s *Symlink) Clone() Item {
+	r := *s
+	r.id = 0
+	return &r
+}
+
+func (s *Symlink) Validate() error {
+	if s.Path == "" {
+		return errors.New("Symlink path is required")
+	}
+	if s.To == "" && !s.Delete {
+		return errors.New("Symlink to is required")
+	}
+	return nil
+}
+
+func (s *Symlink) StaticFiles() []string {
+	return nil
+}
+
+func (s *Symlink) After() []string {
+	if s.Delete {
+		return nil
+	}
+	var afters []string
+	if s.User != "" {
+		afters = append(afters, "user:"+s.User)
+	}
+	if s.Group != "" {
+		afters = append(afters, "group:"+s.Group)
+	}
+	afters = append(afters, s.Requires...)
+	return afters
+}
+func (s *Symlink) Before() []string {
+	return nil
+}
+func (s *Symlink) Provides() []string {
+	return []string{"path:" + s.Path}
+}
+func (s *Symlink) Notifies() []string {
+	return s.Notify
+}
+
+func (s *Symlink) Apply(ctx context.Context, host *Host) (Status, error) {
+	fi, err := host.rh.Lstat(ctx, s.Path)
+	if err != nil && !util.IsErrNotFound(err) {
+		return 0, err
+	}
+	exists := err == nil
+
+	if s.Delete {
+		if !exists {
+			return Unchanged, nil
+		}
+		if !util.IsLink(fi) {
+			return 0, fmt.Errorf("%s is not a symlink, not deleting it", s.Path)
+		}
+		host.Run.out.Active(host.Run, s, Deleted)
+		if err := host.rh.Remove(ctx, s.Path); err != nil {
+			return 0, err
+		}
+		return Deleted, nil
+	}
+
+	status := Unchanged
+	if !exists {
+		status = Created
+	} else if util.IsLink(fi) {
+		to, err := host.rh.Readlink(ctx, s.Path)
+		if err != nil {
+			return 0, err
+		}
+		if to != s.To {
+			status = Modified
+		}
+	} else if fi.IsDir() {
+		return 0, fmt.Errorf("%s is a directory, not a symlink", s.Path)
+	} else if !s.Force {
+		return 0, fmt.Errorf("%s exists and is not a symlink (set force to replace it)", s.Path)
+	} else {
+		status = Modified
+	}
+
+	if status != Unchanged {
+		host.Run.out.Active(host.Run, s, status)
+		if exists {
+			if err := host.rh.Remove(ctx, s.Path); err != nil {
+				return 0, err
+			}
+		}
+		if err := host.rh.Symlink(ctx, s.To, s.Path); err != nil {
+			return 0, err
+		}
+	}
+
+	ostatus, err := s.applyowner(ctx, host)
+	if err != nil {
+		return 0, err
+	}
+	if status == Unchanged {
+		status = ostatus
+	}
+	return status, nil
+}
+
+func (s *Symlink) applyowner(ctx context.Context, host *Host) (Status, error) {
+	if s.User == "" && s.Group == "" {
+		return Unchanged, nil
+	}
+
+	fi, err := host.rh.Lstat(ctx, s.Path)
+	if err != nil {
+		return 0, err
+	}
+	ufi, err := util.ConvertStat(fi)
+	if err != nil {
+		return 0, err
+	}
+	wantuid, wantgid := ufi.Fuid, ufi.Fgid
+
+	if s.User != "" {
+		user, err := host.rh.User(ctx, s.User)
+		if err != nil {
+			return 0, err
+		}
+		if user == nil {
+			return 0, fmt.Errorf("Unknown user %#v", s.User)
+		}
+		wantuid = user.Uid
+	}
+	if s.Group != "" {
+		group, err := host.rh.Group(ctx, s.Group)
+		if err != nil {
+			return 0, err
+		}
+		if group == nil {
+			return 0, fmt.Errorf("Unknown group %#v", s.Group)
+		}
+		wantgid = group.Gid
+	}
+
+	if wantuid == ufi.Fuid && wantgid == ufi.Fgid {
+		return Unchanged, nil
+	}
+	if err := host.rh.Lchown(ctx, s.Path, wantuid, wantgid); err != nil {
+		return 0, err
+	}
+	return Modified, nil
+}
+
+// warnIfLink reports a File or Dir whose path is a symlink: the item ends up
+// managing whatever the link points at (or, for a changed File, replacing
+// the link with a plain file), which is rarely what was meant.
+func warnIfLink(ctx context.Context, host *Host, what, fpath string) {
+	fi, err := host.rh.Lstat(ctx, fpath)
+	if err != nil || !util.IsLink(fi) {
+		return
+	}
+	to, _ := host.rh.Readlink(ctx, fpath)
+	Warnf("Managed %s %s on %s is a symlink to %#v", what, fpath, host.Name, to)
+}
@@ -0,0 +1,171 @@
+package khan
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Target limits an item to some hosts. Item types embed it, so in YAML it
+// shows up as the "hosts" and "when" parameters:
+//
+//	package:
+//	  name: postgresql
+//	  hosts: [db]
+//	  when: os == linux && distro != fedora
+//
+// Hosts entries are host names, inventory group names or glob patterns
+// against either. When is a condition on host facts: name, os, arch, distro,
+// group (true if the host is in the group) and vars.<key> from the
+// inventory. Comparisons are == and !=, combined with && and ||, where &&
+// binds tighter. Both must match for the item to be added to a host.
+type Target struct {
+	Hosts []string
+	When  string
+}
+
+// Targeted is implemented by items that embed Target.
+type Targeted interface {
+	Selects(host *Host, facts map[string]string) (bool, error)
+	CheckTarget() error
+}
+
+type whenclause struct {
+	key   string
+	not   bool
+	value string
+}
+
+// parseWhen returns the condition as a list of alternatives, each a list of
+// clauses that must all hold.
+func parseWhen(s string) ([][]whenclause, error) {
+	var r [][]whenclause
+	for _, or := range strings.Split(s, "||") {
+		var ands []whenclause
+		for _, and := range strings.Split(or, "&&") {
+			var c whenclause
+			op := "=="
+			i := strings.Index(and, op)
+			if j := strings.Index(and, "!="); j > -1 && (i == -1 || j < i) {
+				op = "!="
+				i = j
+				c.not = true
+			}
+			if i == -1 {
+				return nil, fmt.Errorf("Bad condition %#v: Expected key == value or key != value", strings.TrimSpace(and))
+			}
+			c.key = strings.TrimSpace(and[:i])
+			c.value = strings.Trim(strings.TrimSpace(and[i+len(op):]), `"'`)
+			if c.key == "" {
+				return nil, fmt.Errorf("Bad condition %#v: Missing key", strings.TrimSpace(and))
+			}
+			switch c.key {
+			case "name", "os", "arch", "distro", "group":
+			default:
+				if !strings.HasPrefix(c.key, "vars.") {
+					return nil, fmt.Errorf("Bad condition %#v: Unknown key %#v", strings.TrimSpace(and), c.key)
+				}
+			}
+			ands = append(ands, c)
+		}
+		r = append(r, ands)
+	}
+	return r, nil
+}
+
+func (t *Target) CheckTarget() error {
+	for _, pattern := range t.Hosts {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Bad host pattern %#v: %w", pattern, err)
+		}
+	}
+	if t.When != "" {
+		if _, err := parseWhen(t.When); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Target) Selects(host *Host, facts map[string]string) (bool, error) {
+	if len(t.Hosts) > 0 {
+		ok := false
+		for _, pattern := range t.Hosts {
+			if matchHostPattern(pattern, host.Name, host.Groups) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+
+	if t.When == "" {
+		return true, nil
+	}
+
+	when, err := parseWhen(t.When)
+	if err != nil {
+		return false, err
+	}
+	for _, ands := range when {
+		ok := true
+		for _, c := range ands {
+			var eq bool
+			if c.key == "group" {
+				eq = host.InGroup(c.value)
+			} else {
+				eq = facts[c.key] == c.value
+			}
+			if eq == c.not {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// facts returns what Target conditions can look at for a host.
+func (host *Host) facts(ctx context.Context) (map[string]string, error) {
+	info, err := host.rh.Info(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r := map[string]string{
+		"name":   host.Name,
+		"os":     info.OS,
+		"arch":   info.Arch,
+		"distro": info.Distro,
+	}
+	for k, v := range host.Vars {
+		r["vars."+k] = v
+	}
+	return r, nil
+}
+
+// targets reports whether an item should be cloned onto a host. Facts are
+// collected once per host.
+//
+// always have itemsmu locked before calling this
+func (r *Run) targets(item Item, host *Host) (bool, error) {
+	t, ok := item.(Targeted)
+	if !ok {
+		return true, nil
+	}
+	facts, ok := r.facts[host]
+	if !ok {
+		var err error
+		facts, err = host.facts(r.ctx)
+		if err != nil {
+			return false, err
+		}
+		r.facts[host] = facts
+	}
+	return t.Selects(host, facts)
+}
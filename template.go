@@ -29,11 +29,9 @@ func (bdl *bindataloader) Get(path string) (io.Reader, error) {
 	return bdl.run.assetfn(path)
 }
 
-func setContextHostTools(pcontext map[string]interface{}, host *Host) {
+func setContextHostTools(ctx context.Context, pcontext map[string]interface{}, host *Host) {
 	kh := pcontext["khan"].(map[string]interface{})
 	kh["secret"] = func(path string) (map[string]string, error) {
-		ctx := context.Background()
-
 		buf := &bytes.Buffer{}
 		cmd := rio.ReadOnlyCommand(ctx, "vault", "kv", "get", "-format", "json", "secret/"+path)
 		cmd.Shell = true
@@ -48,9 +46,14 @@ func setContextHostTools(pcontext map[string]interface{}, host *Host) {
 		}
 		return vr.Data.Data, nil
 	}
+	kh["host"] = map[string]interface{}{
+		"name":   host.Name,
+		"groups": host.Groups,
+		"vars":   host.Vars,
+	}
 }
 
-func executePackedTemplateFile(host *Host, tfile string) (string, error) {
+func executePackedTemplateFile(ctx context.Context, host *Host, tfile string) (string, error) {
 	host.Run.pongomu.Lock()
 	defer host.Run.pongomu.Unlock()
 
@@ -65,7 +68,7 @@ func executePackedTemplateFile(host *Host, tfile string) (string, error) {
 		v = tpl
 	}
 
-	setContextHostTools(host.Run.pongopackedcontext, host)
+	setContextHostTools(ctx, host.Run.pongopackedcontext, host)
 
 	buf, err := v.ExecuteBytes(host.Run.pongopackedcontext)
 	if err != nil {
@@ -74,7 +77,7 @@ func executePackedTemplateFile(host *Host, tfile string) (string, error) {
 	return string(buf), nil
 }
 
-func executePackedTemplateString(host *Host, s string) (string, error) {
+func executePackedTemplateString(ctx context.Context, host *Host, s string) (string, error) {
 	host.Run.pongomu.Lock()
 	defer host.Run.pongomu.Unlock()
 
@@ -88,7 +91,7 @@ func executePackedTemplateString(host *Host, s string) (string, error) {
 		v = tpl
 	}
 
-	setContextHostTools(host.Run.pongopackedcontext, host)
+	setContextHostTools(ctx, host.Run.pongopackedcontext, host)
 
 	buf, err := v.ExecuteBytes(host.Run.pongopackedcontext)
 	if err != nil {
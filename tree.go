@@ -0,0 +1,347 @@
+package khan
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"khan.rip/rio"
+	"khan.rip/rio/util"
+)
+
+// Tree mirrors a directory from the configurer onto the host. The source is
+// bundled into your khan build output, like File.Src. Each file is compared
+// and written the way a File would be, so unchanged files are left alone.
+type Tree struct {
+	Path string `khan:"path,shortkey"`
+
+	// Src is a directory on the configurer, relative to the project root
+	Src string `khan:"src,shortvalue"`
+
+	User    string
+	Group   string
+	Mode    os.FileMode // for files, default 0644
+	DirMode os.FileMode // for directories, default 0755
+
+	// Modes and Owners override Mode and User/Group for matching files, as
+	// "pattern 0755" and "pattern user[:group]". Patterns are globs on the
+	// path relative to Src, or on the file name if they have no slash. The
+	// last match wins.
+	Modes  []string
+	Owners []string
+
+	// Templates lists file extensions to render with pongo2, e.g. ".j2".
+	// The extension is dropped on the host.
+	Templates []string
+
+	// Purge deletes anything under Path that isn't in Src
+	Purge bool
+
+	// Requires lists extra keys to order after, e.g. "package:nginx"
+	Requires []string
+
+	// Notify lists handlers to trigger when this item changes, e.g. "service:nginx#reload"
+	Notify []string
+
+	// Limits which hosts get this item
+	Target
+
+	id int
+}
+
+type treeRule struct {
+	pattern string
+	value   string
+}
+
+func parseTreeRules(field string, rules []string) ([]treeRule, error) {
+	var r []treeRule
+	for _, rule := range rules {
+		f := strings.Fields(rule)
+		if len(f) != 2 {
+			return nil, fmt.Errorf("Bad %s rule %#v: Expected \"pattern value\"", field, rule)
+		}
+		if _, err := path.Match(f[0], ""); err != nil {
+			return nil, fmt.Errorf("Bad %s pattern %#v: %w", field, f[0], err)
+		}
+		r = append(r, treeRule{pattern: f[0], value: f[1]})
+	}
+	return r, nil
+}
+
+// matchTreeRules returns the value of the last rule matching rel
+func matchTreeRules(rules []treeRule, rel string) string {
+	value := ""
+	for _, rule := range rules {
+		target := rel
+		if !strings.Contains(rule.pattern, "/") {
+			target = path.Base(rel)
+		}
+		if ok, _ := path.Match(rule.pattern, target); ok {
+			value = rule.value
+		}
+	}
+	return value
+}
+
+func (t *Tree) String() string {
+	return t.Path
+}
+
+func (t *Tree) SetID(id int) {
+	t.id = id
+}
+func (t *Tree) ID() int {
+	return t.id
+}
+func (t *Tree) Clone() Item {
+	r := *t
+	r.id = 0
+	return &r
+}
+
+func (t *Tree) Validate() error {
+	if t.Path == "" {
+		return errors.New("Tree path is required")
+	}
+	if t.Src == "" {
+		return errors.New("Tree src is required")
+	}
+	modes, err := parseTreeRules("modes", t.Modes)
+	if err != nil {
+		return err
+	}
+	for _, rule := range modes {
+		if _, err := strconv.ParseUint(rule.value, 8, 32); err != nil {
+			return fmt.Errorf("Bad mode %#v for %#v: Expected octal", rule.value, rule.pattern)
+		}
+	}
+	if _, err := parseTreeRules("owners", t.Owners); err != nil {
+		return err
+	}
+	for _, ext := range t.Templates {
+		if !strings.HasPrefix(ext, ".") {
+			return fmt.Errorf("Template extension %#v should start with a dot", ext)
+		}
+	}
+	return nil
+}
+
+func (t *Tree) StaticFiles() []string {
+	return []string{t.Src}
+}
+
+func (t *Tree) After() []string {
+	var afters []string
+	if t.User != "" {
+		afters = append(afters, "user:"+t.User)
+	}
+	if t.Group != "" {
+		afters = append(afters, "group:"+t.Group)
+	}
+	for _, rule := range t.Owners {
+		if f := strings.Fields(rule); len(f) == 2 {
+			ug := strings.SplitN(f[1], ":", 2)
+			afters = append(afters, "user:"+ug[0])
+			if len(ug) == 2 {
+				afters = append(afters, "group:"+ug[1])
+			}
+		}
+	}
+	afters = append(afters, t.Requires...)
+	return afters
+}
+func (t *Tree) Before() []string {
+	return nil
+}
+func (t *Tree) Provides() []string {
+	return []string{"path:" + t.Path}
+}
+func (t *Tree) Notifies() []string {
+	return t.Notify
+}
+
+// treeEntry is one bundled file and where it goes
+type treeEntry struct {
+	asset    string
+	rel      string // destination, relative to Path
+	template bool
+}
+
+func (t *Tree) entries(r *Run) ([]treeEntry, error) {
+	if r.assetnames == nil {
+		return nil, fmt.Errorf("No bundled files: Tree needs a khan build")
+	}
+
+	prefix := path.Clean(t.Src) + "/"
+	var entries []treeEntry
+	for _, name := range r.assetnames() {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		e := treeEntry{
+			asset: name,
+			rel:   strings.TrimPrefix(name, prefix),
+		}
+		for _, ext := range t.Templates {
+			if strings.HasSuffix(e.rel, ext) && len(e.rel) > len(ext) && !strings.HasSuffix(e.rel, "/"+ext) {
+				e.rel = strings.TrimSuffix(e.rel, ext)
+				e.template = true
+				break
+			}
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("No bundled files under %s", t.Src)
+	}
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].rel < entries[b].rel
+	})
+	return entries, nil
+}
+
+func (t *Tree) Apply(ctx context.Context, host *Host) (Status, error) {
+	entries, err := t.entries(host.Run)
+	if err != nil {
+		return 0, err
+	}
+
+	// Already checked by Validate
+	modes, _ := parseTreeRules("modes", t.Modes)
+	owners, _ := parseTreeRules("owners", t.Owners)
+
+	status := Unchanged
+	merge := func(s Status) {
+		if s != Unchanged && status == Unchanged {
+			status = Modified
+		}
+	}
+
+	// Directories first, parents before children
+	dirs := map[string]bool{"": true}
+	for _, e := range entries {
+		for d := path.Dir(e.rel); d != "."; d = path.Dir(d) {
+			dirs[d] = true
+		}
+	}
+	var dirlist []string
+	for d := range dirs {
+		dirlist = append(dirlist, d)
+	}
+	sort.Strings(dirlist)
+
+	for _, d := range dirlist {
+		dir := &Dir{
+			Path:  path.Join(t.Path, d),
+			User:  t.User,
+			Group: t.Group,
+			Mode:  t.DirMode,
+		}
+		host.Run.addInner(host, t, dir)
+		s, err := dir.Apply(ctx, host)
+		if err != nil {
+			return 0, err
+		}
+		if d == "" && s == Created {
+			status = Created
+		}
+		merge(s)
+	}
+
+	managed := map[string]bool{}
+	for d := range dirs {
+		managed[path.Join(t.Path, d)] = true
+	}
+
+	for _, e := range entries {
+		f := &File{
+			Path:  path.Join(t.Path, e.rel),
+			Src:   e.asset,
+			User:  t.User,
+			Group: t.Group,
+			Mode:  t.Mode,
+		}
+		if e.template {
+			f.Template = "pongo2"
+		}
+		if m := matchTreeRules(modes, e.rel); m != "" {
+			mode, _ := strconv.ParseUint(m, 8, 32)
+			f.Mode = os.FileMode(mode)
+		}
+		if o := matchTreeRules(owners, e.rel); o != "" {
+			ug := strings.SplitN(o, ":", 2)
+			f.User = ug[0]
+			if len(ug) == 2 {
+				f.Group = ug[1]
+			}
+		}
+		managed[f.Path] = true
+
+		host.Run.addInner(host, t, f)
+		s, err := f.Apply(ctx, host)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", f.Path, err)
+		}
+		merge(s)
+	}
+
+	// A directory we just created has nothing unmanaged in it (and in a dry
+	// run it doesn't really exist)
+	if t.Purge && status != Created {
+		s, err := t.purge(ctx, host, managed)
+		if err != nil {
+			return 0, err
+		}
+		merge(s)
+	}
+
+	return status, nil
+}
+
+// purge removes what's under Path but not managed. Unmanaged directories go
+// in one piece.
+func (t *Tree) purge(ctx context.Context, host *Host, managed map[string]bool) (Status, error) {
+	buf := &bytes.Buffer{}
+	cmd := rio.ReadOnlyCommand(ctx, "find", path.Clean(t.Path), "-mindepth", "1", "-print0")
+	cmd.Stdout = buf
+	if err := host.rh.Exec(cmd); err != nil {
+		return 0, err
+	}
+
+	var unmanaged []string
+	for _, p := range strings.Split(buf.String(), "\x00") {
+		if p != "" && !managed[p] {
+			unmanaged = append(unmanaged, p)
+		}
+	}
+	sort.Strings(unmanaged)
+
+	status := Unchanged
+	removed := map[string]bool{}
+	for _, p := range unmanaged {
+		gone := false
+		for d := path.Dir(p); d != "/" && d != "."; d = path.Dir(d) {
+			if removed[d] {
+				gone = true
+				break
+			}
+		}
+		if gone {
+			continue
+		}
+		status = Deleted
+		host.Run.out.Active(host.Run, host.Run.addInner(host, t, &File{Path: p}), Deleted)
+		if err := util.RemoveAll(ctx, host.rh, p); err != nil {
+			return 0, err
+		}
+		removed[p] = true
+	}
+	return status, nil
+}
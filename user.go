@@ -1,10 +1,15 @@
 package khan
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"sort"
+	"strings"
+	"time"
 
 	"khan.rip/rio"
+	"khan.rip/rio/util"
 )
 
 type User struct {
@@ -23,17 +28,50 @@ type User struct {
 	Home  string
 	Shell string
 
+	// CreateHome makes sure Home exists and is the user's, copying
+	// /etc/skel when it's made
+	CreateHome bool `khan:"create_home"`
+
+	// MoveHome moves the old home directory when Home changes. Otherwise
+	// it's left where it was.
+	MoveHome bool `khan:"move_home"`
+
+	// RemoveHome takes the home directory (and mail spool) with Delete
+	RemoveHome bool `khan:"remove_home"`
+
 	// Password is the passord encrypted with libcrypt.
 	// Password if blank will actually be set to "!". If "!", "!!", or "x" are found
 	// in /etc/shadow, it will be translated to a blank password. If you want an actually
 	// blank password (not safe) use BlankPassword: true (blank_password: true in yaml).
+	// In yaml, khan build can make it from password_plain, with
+	// password_scheme. That's salted afresh by each build, so a rebuilt
+	// binary sets it again; PasswordEnv doesn't.
 	Password      string
 	BlankPassword bool `khan:"blank_password"`
 
-	// TODO fancy /etc/shadow fields
+	// PasswordEnv names an environment variable where khan runs that holds
+	// the password itself. A host whose hash already matches it keeps that
+	// hash. Otherwise it's crypted with PasswordScheme ("sha512", the
+	// default, or "bcrypt").
+	PasswordEnv    string `khan:"password_env"`
+	PasswordScheme string `khan:"password_scheme"`
+
+	// Password aging, in days as in /etc/shadow. 0 leaves a field as it is,
+	// and -1 empties it (no limit). OpenBSD only has Expire.
+	MinDays      int `khan:"min_days"`
+	MaxDays      int `khan:"max_days"`
+	WarnDays     int `khan:"warn_days"`
+	InactiveDays int `khan:"inactive_days"`
+
+	// Expire is when the account expires, as YYYY-MM-DD, or "never".
+	// 1970-01-01 locks the account, like chage -E 0.
+	Expire string
 
 	Delete bool
 
+	// Limits which hosts get this item
+	Target
+
 	id int
 }
 
@@ -52,6 +90,103 @@ func (u *User) Clone() Item {
 	r.id = 0
 	return &r
 }
+func (u *User) Validate() error {
+	for _, days := range []int{u.MinDays, u.MaxDays, u.WarnDays, u.InactiveDays} {
+		if days < -1 {
+			return fmt.Errorf("Bad password aging %d for user %s: Expected days, or -1 for no limit", days, u.Name)
+		}
+	}
+	if _, _, err := u.expireDays(); err != nil {
+		return err
+	}
+	switch u.PasswordScheme {
+	case "", PasswordSHA512, PasswordBcrypt:
+	default:
+		return fmt.Errorf("Unknown password scheme %#v for user %s: Expected %#v or %#v", u.PasswordScheme, u.Name, PasswordSHA512, PasswordBcrypt)
+	}
+	if u.PasswordEnv != "" && (u.Password != "" || u.BlankPassword) {
+		return fmt.Errorf("User %s has password_env with password or blank_password", u.Name)
+	}
+	if u.PasswordScheme != "" && u.PasswordEnv == "" {
+		return fmt.Errorf("User %s has password_scheme without password_env", u.Name)
+	}
+	return nil
+}
+
+// crypt is the hash to set: Password, or PasswordEnv's password. That keeps
+// the host's hash if it matches, and gets a fresh salt otherwise.
+func (u *User) crypt(ctx context.Context, host *Host, old *rio.Password) (string, error) {
+	if u.PasswordEnv == "" {
+		return u.Password, nil
+	}
+	plain := os.Getenv(u.PasswordEnv)
+	if plain == "" {
+		return "", fmt.Errorf("Environment variable %s for user %s's password_env is not set", u.PasswordEnv, u.Name)
+	}
+	if old != nil {
+		if VerifyPassword(plain, old.Crypt) {
+			return old.Crypt, nil
+		}
+		// Others, like the yescrypt passwd makes, only the host can check
+		if strings.HasPrefix(old.Crypt, "$") && !verifiable(old.Crypt) && util.VerifyCrypt(ctx, host.rh, plain, old.Crypt) {
+			return old.Crypt, nil
+		}
+	}
+	return CryptPassword(plain, u.PasswordScheme)
+}
+
+// expireDays is Expire as /etc/shadow has it: days since 1970, or -1. The
+// bool is false when Expire isn't set, since 1970-01-01 is day 0.
+func (u *User) expireDays() (int, bool, error) {
+	switch u.Expire {
+	case "":
+		return 0, false, nil
+	case "never":
+		return -1, true, nil
+	}
+	t, err := time.Parse("2006-01-02", u.Expire)
+	if err != nil {
+		return 0, false, fmt.Errorf("Bad expire %#v for user %s: Expected YYYY-MM-DD or \"never\"", u.Expire, u.Name)
+	}
+	if t.Unix() < 0 {
+		return 0, false, fmt.Errorf("Bad expire %#v for user %s: Dates before 1970-01-01 can't be stored", u.Expire, u.Name)
+	}
+	return int(t.Unix() / 86400), true, nil
+}
+
+// password is the shadow entry we want, starting from what's there so
+// aging fields we don't manage stay as they are
+func (u *User) password(old *rio.Password, crypt string) (*rio.Password, error) {
+	p := rio.NoAging(u.Name, crypt)
+	if old != nil {
+		*p = *old
+		p.Crypt = crypt
+	}
+
+	set := func(field *int, days int) {
+		if days != 0 {
+			*field = days
+		}
+	}
+	set(&p.MinDays, u.MinDays)
+	set(&p.MaxDays, u.MaxDays)
+	set(&p.WarnDays, u.WarnDays)
+	set(&p.InactiveDays, u.InactiveDays)
+
+	expire, ok, err := u.expireDays()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		p.Expire = expire
+	}
+	return p, nil
+}
+
+func (u *User) hasAging() bool {
+	return u.MinDays != 0 || u.MaxDays != 0 || u.WarnDays != 0 || u.InactiveDays != 0 || u.Expire != ""
+}
+
 func (u *User) After() []string {
 	if !u.Delete {
 		grp := u.Group
@@ -90,7 +225,7 @@ func (u *User) Provides() []string {
 	}
 }
 
-func (u *User) Apply(host *Host) (Status, error) {
+func (u *User) Apply(ctx context.Context, host *Host) (Status, error) {
 	usergroup := u.Group
 	if usergroup == "" {
 		usergroup = u.Name
@@ -104,7 +239,7 @@ func (u *User) Apply(host *Host) (Status, error) {
 	defaultpw := "!"
 	usershell := u.Shell
 	if usershell == "" {
-		info, err := host.rh.Info()
+		info, err := host.rh.Info(ctx)
 		if err != nil {
 			return 0, err
 		}
@@ -117,7 +252,7 @@ func (u *User) Apply(host *Host) (Status, error) {
 		}
 	}
 
-	old, err := host.rh.User(u.Name)
+	old, err := host.rh.User(ctx, u.Name)
 	if err != nil {
 		return 0, err
 	}
@@ -126,7 +261,7 @@ func (u *User) Apply(host *Host) (Status, error) {
 		if old == nil {
 			return Unchanged, nil
 		}
-		if err := host.rh.DeleteUser(u.Name); err != nil {
+		if err := host.rh.DeleteUser(ctx, u.Name, u.RemoveHome); err != nil {
 			return 0, err
 		}
 		return Deleted, nil
@@ -146,17 +281,30 @@ func (u *User) Apply(host *Host) (Status, error) {
 		Name:  u.Name,
 		Crypt: u.Password,
 	}
-	if u.Password == "" && !u.BlankPassword {
+	if old == nil {
+		if vp.Crypt, err = u.crypt(ctx, host, nil); err != nil {
+			return 0, err
+		}
+	}
+	if vp.Crypt == "" && !u.BlankPassword {
 		vp.Crypt = defaultpw
 	}
 
 	if old == nil {
-		if err := host.rh.CreateUser(v); err != nil {
+		if err := host.rh.CreateUser(ctx, v, u.CreateHome); err != nil {
 			return 0, err
 		}
 
-		if vp.Crypt != defaultpw {
-			if err := host.rh.UpdatePassword(vp); err != nil {
+		if vp.Crypt != defaultpw || u.hasAging() {
+			// Start from the aging useradd gave it
+			newp, err := host.rh.Password(ctx, u.Name)
+			if err != nil {
+				return 0, err
+			}
+			if vp, err = u.password(newp, vp.Crypt); err != nil {
+				return 0, err
+			}
+			if err := host.rh.UpdatePassword(ctx, vp); err != nil {
 				return 0, err
 			}
 		}
@@ -164,13 +312,21 @@ func (u *User) Apply(host *Host) (Status, error) {
 		return Created, nil
 	}
 
+	// Membership in groups with Members is up to those Group items
+	managed := host.Run.memberGroups(host)
+	for _, grp := range old.Groups {
+		if managed[grp] && !containsString(v.Groups, grp) {
+			v.Groups = append(append([]string{}, v.Groups...), grp)
+		}
+	}
+
 	modified := false
 
 	if old.Uid != u.Uid {
 		modified = true
 	}
 
-	oldp, err := host.rh.Password(u.Name)
+	oldp, err := host.rh.Password(ctx, u.Name)
 	if err != nil {
 		return 0, err
 	}
@@ -181,7 +337,16 @@ func (u *User) Apply(host *Host) (Status, error) {
 	if vp == nil {
 		panic("vp is nil!!!!")
 	}
-	if oldp.Crypt != vp.Crypt {
+	if u.PasswordEnv != "" {
+		if vp.Crypt, err = u.crypt(ctx, host, oldp); err != nil {
+			return 0, err
+		}
+	}
+	if vp, err = u.password(oldp, vp.Crypt); err != nil {
+		return 0, err
+	}
+	if *vp != *oldp {
+		// Crypt or aging
 		modified = true
 	}
 
@@ -215,9 +380,9 @@ func (u *User) Apply(host *Host) (Status, error) {
 			}*/
 
 	g1 := make([]string, len(old.Groups))
-	g2 := make([]string, len(u.Groups))
+	g2 := make([]string, len(v.Groups))
 	copy(g1, old.Groups)
-	copy(g2, u.Groups)
+	copy(g2, v.Groups)
 	sort.Strings(g1)
 	sort.Strings(g2)
 	if len(g1) != len(g2) {
@@ -239,14 +404,84 @@ func (u *User) Apply(host *Host) (Status, error) {
 	}
 
 	if modified {
-		if err := host.rh.UpdateUser(v); err != nil {
+		if err := host.rh.UpdateUser(ctx, v, u.MoveHome); err != nil {
 			return 0, err
 		}
-		if err := host.rh.UpdatePassword(vp); err != nil {
+		if err := host.rh.UpdatePassword(ctx, vp); err != nil {
 			return 0, err
 		}
-		return Modified, nil
 	}
 
+	if u.CreateHome {
+		homemodified, err := u.applyHome(ctx, host, userhome)
+		if err != nil {
+			return 0, err
+		}
+		modified = modified || homemodified
+	}
+
+	if modified {
+		return Modified, nil
+	}
 	return Unchanged, nil
 }
+
+// applyHome makes the home directory of a user that already exists, or
+// gives it back to them
+func (u *User) applyHome(ctx context.Context, host *Host, userhome string) (bool, error) {
+	user, err := host.rh.User(ctx, u.Name)
+	if err != nil {
+		return false, err
+	}
+	if user == nil {
+		return false, fmt.Errorf("Unknown user %#v", u.Name)
+	}
+	group, err := host.rh.Group(ctx, user.Group)
+	if err != nil {
+		return false, err
+	}
+	if group == nil {
+		return false, fmt.Errorf("Unknown group %#v", user.Group)
+	}
+
+	fi, err := host.rh.Stat(ctx, userhome)
+	if err != nil {
+		if !util.IsErrNotFound(err) {
+			return false, err
+		}
+		return true, util.CreateHome(ctx, host.rh, userhome, user.Uid, group.Gid)
+	}
+	if !fi.IsDir() {
+		return false, fmt.Errorf("Home %s of user %s is not a directory", userhome, u.Name)
+	}
+
+	ufi, err := util.ConvertStat(fi)
+	if err != nil {
+		return false, err
+	}
+	if ufi.Fuid == user.Uid && ufi.Fgid == group.Gid {
+		return false, nil
+	}
+
+	if ufi.Fuid != user.Uid {
+		// Left by a uid change made outside khan: what's in it is theirs too.
+		// A home that's another user's only gets the directory itself.
+		users, err := host.rh.Users(ctx)
+		if err != nil {
+			return false, err
+		}
+		orphaned := true
+		for _, other := range users {
+			if other.Uid == ufi.Fuid {
+				orphaned = false
+				break
+			}
+		}
+		if orphaned {
+			if err := util.ChownHome(ctx, host.rh, userhome, ufi.Fuid, user.Uid); err != nil {
+				return false, err
+			}
+		}
+	}
+	return true, host.rh.Chown(ctx, userhome, user.Uid, group.Gid)
+}